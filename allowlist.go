@@ -0,0 +1,124 @@
+package bolt
+
+import (
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// AllowlistEntry is a pubkey on the allowlist. A zero Expires means the
+// entry never expires.
+type AllowlistEntry struct {
+	PubKey  string
+	Expires time.Time
+}
+
+// Expired reports whether the entry has expired at now.
+func (e AllowlistEntry) Expired(now time.Time) bool {
+	return !e.Expires.IsZero() && !now.Before(e.Expires)
+}
+
+// The "allowlist" bucket maps 32 byte pubkeys to their expiry as a unix
+// timestamp, with 0 meaning no expiry.
+
+func allowlistKey(pubkey string) ([]byte, error) {
+	pubkeyb, err := hex.DecodeString(pubkey)
+	if err != nil || len(pubkeyb) != 32 {
+		return nil, errors.New("invalid pubkey")
+	}
+	return pubkeyb, nil
+}
+
+func decodeAllowlistEntry(k, v []byte) AllowlistEntry {
+	e := AllowlistEntry{PubKey: hex.EncodeToString(k)}
+	if len(v) == 8 {
+		if ts := binary.BigEndian.Uint64(v); ts != 0 {
+			e.Expires = time.Unix(int64(ts), 0)
+		}
+	}
+	return e
+}
+
+// Allow adds pubkey to the allowlist until expires, or forever if expires is
+// the zero time. Allowing a pubkey that is already listed updates its
+// expiry, which is how paid memberships are renewed.
+func (b *BoltBackend) Allow(pubkey string, expires time.Time) error {
+	key, err := allowlistKey(pubkey)
+	if err != nil {
+		return err
+	}
+	v := make([]byte, 8)
+	if !expires.IsZero() {
+		binary.BigEndian.PutUint64(v, uint64(expires.Unix()))
+	}
+	return b.update(func(tx *bolt.Tx) error {
+		return tx.Bucket([]byte("allowlist")).Put(key, v)
+	})
+}
+
+// Disallow removes pubkey from the allowlist.
+func (b *BoltBackend) Disallow(pubkey string) error {
+	key, err := allowlistKey(pubkey)
+	if err != nil {
+		return err
+	}
+	return b.update(func(tx *bolt.Tx) error {
+		return tx.Bucket([]byte("allowlist")).Delete(key)
+	})
+}
+
+// Allowlist returns every entry on the allowlist, including expired ones.
+func (b *BoltBackend) Allowlist() (entries []AllowlistEntry, err error) {
+	err = b.view(func(tx *bolt.Tx) error {
+		return tx.Bucket([]byte("allowlist")).ForEach(func(k, v []byte) error {
+			entries = append(entries, decodeAllowlistEntry(k, v))
+			return nil
+		})
+	})
+	return
+}
+
+// IsAllowed reports whether pubkey is on the allowlist and hasn't expired.
+// It is meant to be called from a relay's AcceptEvent.
+func (b *BoltBackend) IsAllowed(pubkey string) bool {
+	key, err := allowlistKey(pubkey)
+	if err != nil {
+		return false
+	}
+	var allowed bool
+	b.view(func(tx *bolt.Tx) error {
+		if v := tx.Bucket([]byte("allowlist")).Get(key); v != nil {
+			allowed = !decodeAllowlistEntry(key, v).Expired(time.Now())
+		}
+		return nil
+	})
+	return allowed
+}
+
+// RemoveExpiredAllowlistEntries drops expired entries from the allowlist and
+// returns how many were removed.
+func (b *BoltBackend) RemoveExpiredAllowlistEntries() (n int, err error) {
+	now := time.Now()
+	err = b.update(func(tx *bolt.Tx) error {
+		n = 0
+		allowlist := tx.Bucket([]byte("allowlist"))
+		var keys [][]byte
+		allowlist.ForEach(func(k, v []byte) error {
+			if decodeAllowlistEntry(k, v).Expired(now) {
+				keys = append(keys, append([]byte(nil), k...))
+			}
+			return nil
+		})
+		for _, k := range keys {
+			if err := allowlist.Delete(k); err != nil {
+				return err
+			}
+		}
+		n = len(keys)
+		return nil
+	})
+	return
+}
@@ -0,0 +1,50 @@
+package bolt
+
+import (
+	"os"
+	"testing"
+	"time"
+)
+
+func TestAllowlist(t *testing.T) {
+	f, _ := os.CreateTemp("", "")
+	f.Close()
+	defer os.Remove(f.Name())
+	s := &BoltBackend{DatabaseURL: f.Name()}
+	s.Init()
+
+	member, lapsed, stranger := randHex(32), randHex(32), randHex(32)
+	if err := s.Allow(member, time.Time{}); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Allow(lapsed, time.Now().Add(-time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Allow("abc", time.Time{}); err == nil {
+		t.Error("invalid pubkey allowed")
+	}
+
+	if !s.IsAllowed(member) || s.IsAllowed(lapsed) || s.IsAllowed(stranger) {
+		t.Error("unexpected allowlist checks")
+	}
+	if entries, _ := s.Allowlist(); len(entries) != 2 {
+		t.Error("unexpected allowlist", entries)
+	}
+
+	// renewing a membership updates the expiry
+	s.Allow(lapsed, time.Now().Add(time.Hour))
+	if !s.IsAllowed(lapsed) {
+		t.Error("renewed membership not allowed")
+	}
+
+	s.Allow(lapsed, time.Now().Add(-time.Hour))
+	if n, err := s.RemoveExpiredAllowlistEntries(); err != nil || n != 1 {
+		t.Error("unexpected expired entries", n, err)
+	}
+	if err := s.Disallow(member); err != nil || s.IsAllowed(member) {
+		t.Error("disallowed pubkey still allowed", err)
+	}
+	if entries, _ := s.Allowlist(); len(entries) != 0 {
+		t.Error("unexpected allowlist", entries)
+	}
+}
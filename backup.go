@@ -0,0 +1,104 @@
+package bolt
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// Backup writes a consistent snapshot of the database to w without blocking
+// readers or writers.
+func (b *BoltBackend) Backup(w io.Writer) (n int64, err error) {
+	err = b.view(func(tx *bolt.Tx) error {
+		n, err = tx.WriteTo(w)
+		return err
+	})
+	return
+}
+
+// BackupHandler returns an http.Handler that streams a snapshot of the
+// database as a file download. It should be mounted behind authentication.
+func (b *BoltBackend) BackupHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			w.Header().Set("Allow", "GET, HEAD")
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		// Errors can't be reported once the headers are sent, the client
+		// just sees a truncated body.
+		b.view(func(tx *bolt.Tx) error {
+			name := fmt.Sprintf("%s-%s.db",
+				trimExt(filepath.Base(b.DatabaseURL)),
+				time.Now().UTC().Format("20060102T150405Z"))
+			w.Header().Set("Content-Type", "application/octet-stream")
+			w.Header().Set("Content-Length", strconv.FormatInt(tx.Size(), 10))
+			w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", name))
+			if r.Method == http.MethodHead {
+				return nil
+			}
+			_, err := tx.WriteTo(w)
+			return err
+		})
+	})
+}
+
+func trimExt(name string) string {
+	return name[:len(name)-len(filepath.Ext(name))]
+}
+
+// Restore copies a backup read from r to path after checking that it is a
+// BoltBackend database whose schema version this package supports. The
+// backup is staged next to path and only renamed over it once checked, so
+// path must not be open by a running backend.
+func Restore(r io.Reader, path string) error {
+	tmp := path + ".restore"
+	f, err := os.OpenFile(tmp, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp)
+	if _, err := io.Copy(f, r); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+
+	db, err := bolt.Open(tmp, 0600, &bolt.Options{Timeout: defaultOpenTimeout, ReadOnly: true})
+	if err != nil {
+		return fmt.Errorf("backup is not a bolt database: %w", err)
+	}
+	err = db.View(func(tx *bolt.Tx) error {
+		version, ok := readSchemaVersion(tx)
+		if !ok {
+			return errors.New("backup is not a BoltBackend database")
+		}
+		if version > schemaVersion {
+			return fmt.Errorf("backup schema version %d is newer than supported version %d", version, schemaVersion)
+		}
+		for err := range tx.Check() {
+			return fmt.Errorf("backup failed check: %w", err)
+		}
+		return nil
+	})
+	if cerr := db.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
@@ -0,0 +1,74 @@
+package bolt
+
+import (
+	"bytes"
+	"context"
+	"net/http/httptest"
+	"os"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/fiatjaf/relayer/v2"
+	"github.com/nbd-wtf/go-nostr"
+	bolt "go.etcd.io/bbolt"
+)
+
+func TestBackupRestore(t *testing.T) {
+	f, _ := os.CreateTemp("", "")
+	f.Close()
+	defer os.Remove(f.Name())
+	s := &BoltBackend{DatabaseURL: f.Name()}
+	s.Init()
+	s.DB.MaxBatchSize = 0
+
+	ids, _, _ := setupStorage([]relayer.Storage{s}, 100)
+
+	var buf bytes.Buffer
+	n, err := s.Backup(&buf)
+	if err != nil || n != int64(buf.Len()) {
+		t.Fatal("backup failed", n, err)
+	}
+
+	rec := httptest.NewRecorder()
+	s.BackupHandler().ServeHTTP(rec, httptest.NewRequest("GET", "/backup", nil))
+	if rec.Header().Get("Content-Length") != strconv.Itoa(rec.Body.Len()) {
+		t.Error("bad content length", rec.Header().Get("Content-Length"), rec.Body.Len())
+	}
+	if !strings.HasPrefix(rec.Header().Get("Content-Disposition"), "attachment; filename=") {
+		t.Error("bad content disposition", rec.Header().Get("Content-Disposition"))
+	}
+
+	path := f.Name() + ".restored"
+	defer os.Remove(path)
+	if err := Restore(rec.Body, path); err != nil {
+		t.Fatal("restore failed", err)
+	}
+	r := &BoltBackend{DatabaseURL: path}
+	if err := r.Init(); err != nil {
+		t.Fatal(err)
+	}
+	ch, _ := r.QueryEvents(context.Background(), &nostr.Filter{IDs: ids[:10]})
+	var i int
+	for range ch {
+		i++
+	}
+	if i != 10 {
+		t.Error("unexpected number of events", i)
+	}
+	r.DB.Update(func(tx *bolt.Tx) error {
+		return writeSchemaVersion(tx, schemaVersion+1)
+	})
+	buf.Reset()
+	r.Backup(&buf)
+	r.DB.Close()
+
+	if err := Restore(&buf, path+".newer"); err == nil {
+		os.Remove(path + ".newer")
+		t.Error("restored backup with newer schema")
+	}
+	if err := Restore(strings.NewReader("not a database"), path+".garbage"); err == nil {
+		os.Remove(path + ".garbage")
+		t.Error("restored garbage")
+	}
+}
@@ -0,0 +1,197 @@
+package bolt
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"time"
+
+	"github.com/nbd-wtf/go-nostr"
+	bolt "go.etcd.io/bbolt"
+)
+
+// BlockType selects what a blocklist entry matches.
+type BlockType byte
+
+// Blocklist entries are kept in the "blocklist" bucket under the BlockType
+// followed by the 32 byte pubkey, event id or content hash, with the time
+// they were added as value.
+const (
+	BlockPubKey  BlockType = 'p'
+	BlockEvent   BlockType = 'e'
+	BlockContent BlockType = 'c'
+)
+
+// ContentHash returns the hex encoded hash used to block events by content.
+func ContentHash(content string) string {
+	h := sha256.Sum256([]byte(content))
+	return hex.EncodeToString(h[:])
+}
+
+func blocklistKey(t BlockType, value string) ([]byte, error) {
+	if t != BlockPubKey && t != BlockEvent && t != BlockContent {
+		return nil, errors.New("unknown block type")
+	}
+	v, err := hex.DecodeString(value)
+	if err != nil || len(v) != 32 {
+		return nil, errors.New("blocked value must be 64 hex characters")
+	}
+	return append([]byte{byte(t)}, v...), nil
+}
+
+// Block adds value, a hex pubkey, event id or ContentHash, to the blocklist.
+// With purge set, events already stored that match the new entry are
+// deleted: all events by a blocked pubkey, or the blocked event itself.
+// Purging doesn't apply to content hashes, which aren't indexed.
+func (b *BoltBackend) Block(ctx context.Context, t BlockType, value string, purge bool) (purged int, err error) {
+	key, err := blocklistKey(t, value)
+	if err != nil {
+		return 0, err
+	}
+	v := make([]byte, 8)
+	binary.BigEndian.PutUint64(v, uint64(time.Now().Unix()))
+	if err := b.update(func(tx *bolt.Tx) error {
+		return tx.Bucket([]byte("blocklist")).Put(key, v)
+	}); err != nil {
+		return 0, err
+	}
+	if !purge {
+		return 0, nil
+	}
+	switch t {
+	case BlockPubKey:
+		return b.purgeAuthor(ctx, key[1:])
+	case BlockEvent:
+		err := b.update(func(tx *bolt.Tx) error {
+			return b.deleteEvent(tx, key[1:])
+		})
+		if errors.Is(err, ErrEventNotFound) {
+			return 0, nil
+		}
+		if err != nil {
+			return 0, err
+		}
+		return 1, nil
+	}
+	return 0, nil
+}
+
+// Unblock removes value from the blocklist.
+func (b *BoltBackend) Unblock(t BlockType, value string) error {
+	key, err := blocklistKey(t, value)
+	if err != nil {
+		return err
+	}
+	return b.update(func(tx *bolt.Tx) error {
+		return tx.Bucket([]byte("blocklist")).Delete(key)
+	})
+}
+
+// Blocked lists the hex values on the blocklist with type t.
+func (b *BoltBackend) Blocked(t BlockType) (values []string, err error) {
+	err = b.view(func(tx *bolt.Tx) error {
+		c := tx.Bucket([]byte("blocklist")).Cursor()
+		for k, _ := c.Seek([]byte{byte(t)}); k != nil && k[0] == byte(t); k, _ = c.Next() {
+			values = append(values, hex.EncodeToString(k[1:]))
+		}
+		return nil
+	})
+	return
+}
+
+// purgeBatchSize bounds the number of events deleted per transaction when
+// purging an author.
+const purgeBatchSize = 1000
+
+// purgeAuthor deletes every event by pubkey using the authors index.
+// Index entries whose event is gone or unreadable are dropped on their own,
+// as pruneKey does, so they can't stop the purge.
+func (b *BoltBackend) purgeAuthor(ctx context.Context, pubkey []byte) (purged int, err error) {
+	for {
+		if err := ctx.Err(); err != nil {
+			return purged, err
+		}
+		var n, deleted int
+		err := b.update(func(tx *bolt.Tx) error {
+			n, deleted = 0, 0
+			author := tx.Bucket([]byte("authors")).Bucket(pubkey)
+			if author == nil {
+				return nil
+			}
+			var keys [][]byte
+			c := author.Cursor()
+			for k, _ := c.First(); k != nil && len(keys) < purgeBatchSize; k, _ = c.Next() {
+				keys = append(keys, append([]byte(nil), k...))
+			}
+			for _, k := range keys {
+				err := b.deleteEvent(tx, k[8:])
+				if errors.Is(err, ErrEventNotFound) || errors.Is(err, ErrCorruptIndex) {
+					b.logf("purge dropped index entry for event %x: %v", k[8:], err)
+					if err := author.Delete(k); err != nil {
+						return err
+					}
+					continue
+				}
+				if err != nil {
+					return err
+				}
+				deleted++
+			}
+			n = len(keys)
+			return nil
+		})
+		if err != nil {
+			return purged, err
+		}
+		purged += deleted
+		if n < purgeBatchSize {
+			return purged, nil
+		}
+	}
+}
+
+// blocklistChecker matches events against the blocklist within a single
+// transaction. It costs nothing when the blocklist is empty.
+type blocklistChecker struct {
+	bucket *bolt.Bucket
+	key    []byte
+}
+
+func newBlocklistChecker(tx *bolt.Tx) *blocklistChecker {
+	bucket := tx.Bucket([]byte("blocklist"))
+	if bucket == nil {
+		return &blocklistChecker{}
+	}
+	if k, _ := bucket.Cursor().First(); k == nil {
+		return &blocklistChecker{}
+	}
+	return &blocklistChecker{bucket: bucket, key: make([]byte, 33)}
+}
+
+func (bc *blocklistChecker) has(t BlockType, value []byte) bool {
+	bc.key[0] = byte(t)
+	copy(bc.key[1:], value)
+	return bc.bucket.Get(bc.key) != nil
+}
+
+// blocked reports whether evt's pubkey, id or content is blocked.
+func (bc *blocklistChecker) blocked(evt *nostr.Event) bool {
+	if bc.bucket == nil {
+		return false
+	}
+	var v [32]byte
+	if len(evt.PubKey) == 64 {
+		if n, _ := hex.Decode(v[:], []byte(evt.PubKey)); n == 32 && bc.has(BlockPubKey, v[:]) {
+			return true
+		}
+	}
+	if len(evt.ID) == 64 {
+		if n, _ := hex.Decode(v[:], []byte(evt.ID)); n == 32 && bc.has(BlockEvent, v[:]) {
+			return true
+		}
+	}
+	v = sha256.Sum256([]byte(evt.Content))
+	return bc.has(BlockContent, v[:])
+}
@@ -0,0 +1,90 @@
+package bolt
+
+import (
+	"context"
+	"encoding/hex"
+	"os"
+	"testing"
+
+	"github.com/nbd-wtf/go-nostr"
+	bolt "go.etcd.io/bbolt"
+)
+
+func TestBlocklist(t *testing.T) {
+	f, _ := os.CreateTemp("", "")
+	f.Close()
+	defer os.Remove(f.Name())
+	s := &BoltBackend{DatabaseURL: f.Name()}
+	s.Init()
+	s.DB.MaxBatchSize = 0
+
+	ctx := context.Background()
+	spammer := randHex(32)
+	var spam []nostr.Event
+	for i := 0; i < 20; i++ {
+		e := nostr.Event{
+			ID:        randHex(32),
+			PubKey:    spammer,
+			CreatedAt: nostr.Timestamp(int64(100 + i)),
+			Kind:      1,
+			Content:   "spam",
+			Sig:       randHex(64),
+		}
+		s.SaveEvent(ctx, &e)
+		spam = append(spam, e)
+		e.ID = randHex(32)
+		e.PubKey = randHex(32)
+		e.Content = "ham"
+		s.SaveEvent(ctx, &e)
+	}
+
+	// blocked content is hidden from queries without being deleted
+	if _, err := s.Block(ctx, BlockContent, ContentHash("spam"), false); err != nil {
+		t.Fatal(err)
+	}
+	if n := countEvents(s, &nostr.Filter{Kinds: []int{1}, Limit: 10}); n != 10 {
+		t.Error("blocked events counted against limit", n)
+	}
+	if n := countEvents(s, &nostr.Filter{Authors: []string{spammer}}); n != 0 {
+		t.Error("blocked events returned", n)
+	}
+	// a stored event is reported as blocked rather than as a duplicate
+	if err := s.SaveEvent(ctx, &spam[0]); err != ErrBlocked {
+		t.Error("expected ErrBlocked for a stored blocked event", err)
+	}
+	if err := s.Unblock(BlockContent, ContentHash("spam")); err != nil {
+		t.Fatal(err)
+	}
+	if n := countEvents(s, &nostr.Filter{Authors: []string{spammer}}); n != 20 {
+		t.Error("unblocked events not returned", n)
+	}
+
+	// an authors entry whose event is gone doesn't stop the purge
+	pubkeyb, _ := hex.DecodeString(spammer)
+	orphan, _ := hex.DecodeString(randHex(32))
+	s.DB.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket([]byte("authors")).Bucket(pubkeyb).Put(append(make([]byte, 8), orphan...), nil)
+	})
+	n, err := s.Block(ctx, BlockPubKey, spammer, true)
+	if err != nil || n != 20 {
+		t.Error("unexpected purge", n, err)
+	}
+	if blocked, _ := s.Blocked(BlockPubKey); len(blocked) != 1 || blocked[0] != spammer {
+		t.Error("unexpected blocklist", blocked)
+	}
+	if err := s.SaveEvent(ctx, &spam[0]); err != ErrBlocked {
+		t.Error("blocked pubkey saved", err)
+	}
+	if r, err := s.Verify(ctx); err != nil || !r.OK() || r.Events != 20 {
+		t.Error("unexpected problems after purge", r.Problems, err)
+	}
+
+	s.Unblock(BlockPubKey, spammer)
+	s.Block(ctx, BlockEvent, spam[1].ID, false)
+	if err := s.SaveEvent(ctx, &spam[1]); err != ErrBlocked {
+		t.Error("blocked event saved", err)
+	}
+	if err := s.SaveEvent(ctx, &spam[2]); err != nil {
+		t.Error("unblocked pubkey rejected", err)
+	}
+}
@@ -1,10 +1,157 @@
 package bolt
 
 import (
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/nbd-wtf/go-nostr"
 	bolt "go.etcd.io/bbolt"
 )
 
 type BoltBackend struct {
 	*bolt.DB
 	DatabaseURL string
+	// Options tunes the bolt database and must be set before Init.
+	Options Options
+	// VerifyEvents makes SaveEvent check ids and signatures before writing.
+	VerifyEvents bool
+	// MaxAuthorEvents and MaxAuthorBytes limit how much a single pubkey can
+	// store. Zero means no limit.
+	MaxAuthorEvents uint64
+	MaxAuthorBytes  uint64
+	// Retention lists the rules applied by Prune.
+	Retention []RetentionRule
+	// MaxSize caps the database size in bytes. Once it is nearly reached the
+	// oldest events are evicted, except those with a protected kind or
+	// pubkey, and the file is compacted. Saves are rejected with
+	// ErrDatabaseFull while the database is at MaxSize, which the writes
+	// already in flight may overshoot by a batch. Zero means no limit.
+	MaxSize               int64
+	EvictProtectedKinds   []int
+	EvictProtectedPubKeys []string
+	// TombstoneLifetime is how long a deleted event is kept from being
+	// saved again. Zero means forever.
+	TombstoneLifetime time.Duration
+	// RestrictPrivateKinds makes QueryEvents behave like QueryEventsAs with
+	// the pubkey relayer stores in the context after NIP-42 authentication.
+	RestrictPrivateKinds bool
+	// MaxQueryKeys and MaxQueryDuration bound the work done by a single
+	// query. A query that runs out stops early with the events found so
+	// far. Zero means no limit.
+	MaxQueryKeys     int
+	MaxQueryDuration time.Duration
+	// Logger receives the backend's log lines. It defaults to the standard
+	// logger; use log.New(io.Discard, "", 0) to silence it.
+	Logger Logger
+	// Operations running longer than SlowThreshold, one second by default,
+	// are logged while they run and passed to OnSlowQuery or OnSlowSave
+	// once they complete.
+	SlowThreshold time.Duration
+	OnSlowQuery   func(filter *nostr.Filter, stats QueryStats)
+	OnSlowSave    func(evt *nostr.Event, d time.Duration)
+	// StatsTopAuthors is the number of authors listed by Stats, 10 by
+	// default.
+	StatsTopAuthors int
+
+	// dbMu guards DB, which is replaced when the database is compacted,
+	// and dbReaders, which counts the reads still using it. Reads only
+	// hold dbMu while they pick up DB, so replacing it never waits for
+	// them; the previous database is closed once they are done.
+	dbMu      sync.RWMutex
+	dbReaders *sync.WaitGroup
+	retiring  sync.WaitGroup
+	// writeMu is held for reading by every write transaction and for
+	// writing while the database is compacted, so no write is lost.
+	writeMu  sync.RWMutex
+	evicting atomic.Bool
+	monitor  slowMonitor
+	metrics  metrics
+	closer   closer
+	// evictStuck is the used size at which eviction last ran out of
+	// unprotected events, zero if it didn't.
+	evictStuck atomic.Int64
+	// snapshot describes the file opened by a read-only backend that
+	// refreshes it. It is guarded by dbMu.
+	snapshot    os.FileInfo
+	refreshQuit chan struct{}
+	refreshDone chan struct{}
+}
+
+func (b *BoltBackend) view(fn func(tx *bolt.Tx) error) error {
+	done, err := b.begin()
+	if err != nil {
+		return err
+	}
+	defer done()
+	return b.viewBegun(fn)
+}
+
+// viewBegun is view for operations already registered with begin.
+func (b *BoltBackend) viewBegun(fn func(tx *bolt.Tx) error) error {
+	defer b.metrics.tx[txView].since(time.Now())
+	db, release := b.acquireDB()
+	defer release()
+	return db.View(fn)
+}
+
+// acquireDB returns the current database, which stays open until release
+// is called even if it is replaced in the meantime.
+func (b *BoltBackend) acquireDB() (db *bolt.DB, release func()) {
+	b.dbMu.RLock()
+	defer b.dbMu.RUnlock()
+	b.dbReaders.Add(1)
+	return b.DB, b.dbReaders.Done
+}
+
+// replaceDB makes db the current database and closes the previous one in
+// the background once the reads still using it are done.
+func (b *BoltBackend) replaceDB(db *bolt.DB) {
+	b.dbMu.Lock()
+	old, readers := b.DB, b.dbReaders
+	b.DB, b.dbReaders = db, &sync.WaitGroup{}
+	b.dbMu.Unlock()
+	b.retiring.Add(1)
+	go func() {
+		defer b.retiring.Done()
+		readers.Wait()
+		if err := old.Close(); err != nil {
+			b.logf("closing replaced database: %v", err)
+		}
+	}()
+}
+
+func (b *BoltBackend) update(fn func(tx *bolt.Tx) error) error {
+	if b.Options.ReadOnly {
+		return ErrReadOnly
+	}
+	done, err := b.begin()
+	if err != nil {
+		return err
+	}
+	defer done()
+	defer b.metrics.tx[txUpdate].since(time.Now())
+	b.writeMu.RLock()
+	defer b.writeMu.RUnlock()
+	b.dbMu.RLock()
+	defer b.dbMu.RUnlock()
+	return b.DB.Update(fn)
+}
+
+func (b *BoltBackend) batch(fn func(tx *bolt.Tx) error) error {
+	if b.Options.ReadOnly {
+		return ErrReadOnly
+	}
+	done, err := b.begin()
+	if err != nil {
+		return err
+	}
+	defer done()
+	defer b.metrics.tx[txBatch].since(time.Now())
+	b.writeMu.RLock()
+	defer b.writeMu.RUnlock()
+	b.dbMu.RLock()
+	defer b.dbMu.RUnlock()
+	return b.DB.Batch(fn)
 }
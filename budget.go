@@ -0,0 +1,125 @@
+package bolt
+
+import (
+	"context"
+	"time"
+)
+
+// QueryStats describes how a query ran. The fields are only complete once
+// the query's channel has been closed.
+type QueryStats struct {
+	Plan QueryPlan
+	// KeysScanned counts the cursor moves and lookups made by the query.
+	KeysScanned int
+	// Decoded counts the events read from the events bucket, some of
+	// which may not be emitted because they are blocked or private.
+	Decoded int
+	Emitted int
+	// Truncated is set when the query stopped early because it ran out of
+	// MaxQueryKeys or MaxQueryDuration.
+	Truncated bool
+	// Canceled is set when the query stopped because its context was
+	// canceled or the backend was closed.
+	Canceled bool
+	Duration time.Duration
+}
+
+// QueryPlan names the strategy used to run a query.
+type QueryPlan string
+
+const (
+	// PlanTimestamps walks every event from newest to oldest.
+	PlanTimestamps QueryPlan = "timestamps"
+	// PlanIDs looks up full ids in the events bucket.
+	PlanIDs QueryPlan = "ids"
+	// PlanIDPrefixes seeks id prefixes in the events bucket.
+	PlanIDPrefixes QueryPlan = "id_prefixes"
+	// PlanIndexes intersects the author, kind and tag indexes.
+	PlanIndexes QueryPlan = "indexes"
+	// PlanUnsupported is used for filters combining ids with other
+	// conditions, which match nothing.
+	PlanUnsupported QueryPlan = "unsupported"
+)
+
+var queryPlans = [...]QueryPlan{PlanTimestamps, PlanIDs, PlanIDPrefixes, PlanIndexes, PlanUnsupported}
+
+// budgetCheckInterval is how many keys are scanned between checks of the
+// query deadline, to keep calls to time.Now off the hot path.
+const budgetCheckInterval = 256
+
+// queryBudget counts the keys scanned by a query and stops it once it has
+// exceeded its key or time budget or its context is done.
+type queryBudget struct {
+	ctx      context.Context
+	stats    *QueryStats
+	maxKeys  int
+	deadline time.Time
+}
+
+func (b *BoltBackend) newQueryBudget(ctx context.Context, stats *QueryStats) *queryBudget {
+	qb := &queryBudget{ctx: ctx, stats: stats, maxKeys: b.MaxQueryKeys}
+	if b.MaxQueryDuration > 0 {
+		qb.deadline = time.Now().Add(b.MaxQueryDuration)
+	}
+	return qb
+}
+
+// spend accounts for scanning one key and reports whether the query may
+// go on.
+func (qb *queryBudget) spend() bool {
+	if qb.stats.Truncated || qb.stats.Canceled {
+		return false
+	}
+	qb.stats.KeysScanned++
+	if qb.maxKeys > 0 && qb.stats.KeysScanned > qb.maxKeys {
+		qb.stats.Truncated = true
+		return false
+	}
+	if (qb.stats.KeysScanned-1)%budgetCheckInterval == 0 {
+		if qb.ctx.Err() != nil {
+			qb.stats.Canceled = true
+			return false
+		}
+		if !qb.deadline.IsZero() && time.Now().After(qb.deadline) {
+			qb.stats.Truncated = true
+			return false
+		}
+	}
+	return true
+}
+
+// cursor wraps c so that every move spends from the budget. Once the budget
+// is exhausted the cursor reports no more keys, which ends and/or cursors
+// built on top of it as well. A nil budget leaves c as is.
+func (qb *queryBudget) cursor(c CursorLike) CursorLike {
+	if qb == nil {
+		return c
+	}
+	return &budgetCursor{c: c, qb: qb}
+}
+
+type budgetCursor struct {
+	c  CursorLike
+	qb *queryBudget
+}
+
+func (bc *budgetCursor) Last() (key, value []byte) {
+	if !bc.qb.spend() {
+		return nil, nil
+	}
+	return bc.c.Last()
+}
+
+func (bc *budgetCursor) Prev() (key, value []byte) {
+	if !bc.qb.spend() {
+		return nil, nil
+	}
+	return bc.c.Prev()
+}
+
+func (bc *budgetCursor) Seek(seek []byte) (key, value []byte) {
+	if !bc.qb.spend() {
+		return nil, nil
+	}
+	return bc.c.Seek(seek)
+}
@@ -0,0 +1,97 @@
+package bolt
+
+import (
+	"context"
+	"sync"
+)
+
+// closer keeps track of the operations running on a backend so Close can
+// wait for them, and of the contexts to cancel to stop the long ones.
+type closer struct {
+	mu      sync.Mutex
+	closed  bool
+	ops     sync.WaitGroup
+	cancels map[uint64]context.CancelFunc
+	next    uint64
+	once    sync.Once
+}
+
+// begin registers an operation, failing with ErrClosed once Close has been
+// called. done must be called when the operation completes.
+func (b *BoltBackend) begin() (done func(), err error) {
+	c := &b.closer
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.closed {
+		return nil, ErrClosed
+	}
+	c.ops.Add(1)
+	return c.ops.Done, nil
+}
+
+// beginCancelable is like begin for operations that may run for a long
+// time. The returned context is canceled when ctx is or when Close is
+// called.
+func (b *BoltBackend) beginCancelable(ctx context.Context) (context.Context, func(), error) {
+	c := &b.closer
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.closed {
+		return nil, nil, ErrClosed
+	}
+	c.ops.Add(1)
+	ctx, cancel := context.WithCancel(ctx)
+	if c.cancels == nil {
+		c.cancels = make(map[uint64]context.CancelFunc)
+	}
+	c.next++
+	id := c.next
+	c.cancels[id] = cancel
+	return ctx, func() {
+		cancel()
+		c.mu.Lock()
+		delete(c.cancels, id)
+		c.mu.Unlock()
+		c.ops.Done()
+	}, nil
+}
+
+// Close stops accepting new operations, which then fail with ErrClosed,
+// cancels the queries and background work in progress and waits for the
+// writes in progress to finish before closing the database. If ctx is done
+// first Close returns its error and leaves the database open; it may be
+// called again to finish closing.
+//
+// Close shadows the embedded DB.Close, which doesn't wait for anything and
+// blocks forever if a query's channel has been abandoned.
+func (b *BoltBackend) Close(ctx context.Context) error {
+	c := &b.closer
+	c.mu.Lock()
+	c.closed = true
+	for _, cancel := range c.cancels {
+		cancel()
+	}
+	c.mu.Unlock()
+
+	drained := make(chan struct{})
+	go func() {
+		c.ops.Wait()
+		close(drained)
+	}()
+	select {
+	case <-drained:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+
+	var err error
+	c.once.Do(func() {
+		b.stopMonitor()
+		b.stopRefresh()
+		b.retiring.Wait()
+		b.dbMu.Lock()
+		defer b.dbMu.Unlock()
+		err = b.DB.Close()
+	})
+	return err
+}
@@ -0,0 +1,79 @@
+package bolt
+
+import (
+	"context"
+	"errors"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/nbd-wtf/go-nostr"
+)
+
+func TestClose(t *testing.T) {
+	f, _ := os.CreateTemp("", "")
+	f.Close()
+	defer os.Remove(f.Name())
+	s := &BoltBackend{DatabaseURL: f.Name()}
+	s.Init()
+	s.DB.MaxBatchSize = 0
+
+	ctx := context.Background()
+	for i := 0; i < 10; i++ {
+		e := nostr.Event{ID: randHex(32), PubKey: randHex(32), CreatedAt: nostr.Timestamp(i), Kind: 1, Sig: randHex(64)}
+		if err := s.SaveEvent(ctx, &e); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// abandon a query after reading a single event
+	ch, err := s.QueryEvents(ctx, &nostr.Filter{Kinds: []int{1}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	<-ch
+
+	// an operation that is still running holds up Close until it is done
+	done, _ := s.begin()
+	short, cancel := context.WithTimeout(ctx, 50*time.Millisecond)
+	defer cancel()
+	if err := s.Close(short); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("Close returned %v, want context.DeadlineExceeded", err)
+	}
+	if err := s.SaveEvent(ctx, &nostr.Event{ID: randHex(32), PubKey: randHex(32), Kind: 1}); err != ErrClosed {
+		t.Errorf("SaveEvent returned %v after Close, want ErrClosed", err)
+	}
+	done()
+
+	timeout, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+	if err := s.Close(timeout); err != nil {
+		t.Fatal(err)
+	}
+	for range ch {
+	}
+	if _, err := s.QueryEvents(ctx, &nostr.Filter{Kinds: []int{1}}); err != ErrClosed {
+		t.Errorf("QueryEvents returned %v after Close, want ErrClosed", err)
+	}
+	if _, err := s.Stats(ctx); err != ErrClosed {
+		t.Errorf("Stats returned %v after Close, want ErrClosed", err)
+	}
+	if err := s.Close(ctx); err != nil {
+		t.Errorf("second Close returned %v", err)
+	}
+}
+
+func TestCloseUnused(t *testing.T) {
+	f, _ := os.CreateTemp("", "")
+	f.Close()
+	defer os.Remove(f.Name())
+	s := &BoltBackend{DatabaseURL: f.Name()}
+	s.Init()
+
+	ctx := context.Background()
+	if err := s.Close(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.SaveEvent(ctx, &nostr.Event{ID: randHex(32), PubKey: randHex(32), Kind: 1}); err != ErrClosed {
+		t.Errorf("SaveEvent returned %v after Close, want ErrClosed", err)
+	}
+}
@@ -0,0 +1,138 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/nbd-wtf/go-nostr"
+
+	bolt "github.com/lnproxy/boltdb-relayer-storage"
+)
+
+func export(args []string) error {
+	fs := flag.NewFlagSet("export", flag.ExitOnError)
+	filterJSON := fs.String("filter", "", "only export events matching this nostr filter, given as JSON")
+	out := fs.String("o", "-", "output file")
+	b, err := open(fs, args, openReadOnly)
+	if err != nil {
+		return err
+	}
+	defer b.Close(context.Background())
+
+	var filter *nostr.Filter
+	if *filterJSON != "" {
+		filter = &nostr.Filter{}
+		if err := json.Unmarshal([]byte(*filterJSON), filter); err != nil {
+			return fmt.Errorf("invalid filter: %w", err)
+		}
+	}
+
+	w := os.Stdout
+	if *out != "-" {
+		if w, err = os.Create(*out); err != nil {
+			return err
+		}
+		defer w.Close()
+	}
+	bw := bufio.NewWriter(w)
+	enc := json.NewEncoder(bw)
+	r, err := b.ScanEvents(context.Background(), filter, func(evt *nostr.Event) error {
+		return enc.Encode(evt)
+	})
+	if err != nil {
+		return err
+	}
+	if err := bw.Flush(); err != nil {
+		return err
+	}
+	log.Printf("exported %d events", r.Events)
+	if r.Skipped > 0 {
+		log.Printf("skipped %d events that can't be decoded", r.Skipped)
+	}
+	return nil
+}
+
+func importEvents(args []string) error {
+	fs := flag.NewFlagSet("import", flag.ExitOnError)
+	in := fs.String("i", "-", "input file")
+	verify := fs.Bool("verify", false, "check event ids and signatures")
+	workers := fs.Int("j", 16, "number of concurrent writers")
+	b, err := open(fs, args, openOrCreate)
+	if err != nil {
+		return err
+	}
+	defer b.Close(context.Background())
+	b.VerifyEvents = *verify
+
+	r := io.Reader(os.Stdin)
+	if *in != "-" {
+		f, err := os.Open(*in)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		r = f
+	}
+
+	var saved, dups, rejected atomic.Int64
+	ctx := context.Background()
+	lines := make(chan []byte, *workers)
+	var wg sync.WaitGroup
+	for i := 0; i < *workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for line := range lines {
+				evt := &nostr.Event{}
+				if err := json.Unmarshal(line, evt); err != nil {
+					rejected.Add(1)
+					continue
+				}
+				switch err := b.SaveEvent(ctx, evt); {
+				case err == nil:
+					saved.Add(1)
+				case errors.Is(err, bolt.ErrDupEvent):
+					dups.Add(1)
+				default:
+					rejected.Add(1)
+					log.Printf("rejected %s: %v", evt.ID, err)
+				}
+			}
+		}()
+	}
+
+	progress := time.NewTicker(5 * time.Second)
+	defer progress.Stop()
+	start := time.Now()
+	sc := bufio.NewScanner(r)
+	sc.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for sc.Scan() {
+		if len(sc.Bytes()) == 0 {
+			continue
+		}
+		lines <- append([]byte(nil), sc.Bytes()...)
+		select {
+		case <-progress.C:
+			log.Printf("%d saved, %d duplicate, %d rejected (%s)",
+				saved.Load(), dups.Load(), rejected.Load(), time.Since(start).Round(time.Second))
+		default:
+		}
+	}
+	close(lines)
+	wg.Wait()
+	if err := sc.Err(); err != nil {
+		return err
+	}
+	fmt.Printf("%d saved, %d duplicate, %d rejected\n", saved.Load(), dups.Load(), rejected.Load())
+	return nil
+}
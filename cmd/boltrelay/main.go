@@ -0,0 +1,207 @@
+// Command boltrelay performs maintenance tasks on a BoltBackend database.
+//
+// Usage:
+//
+//	boltrelay <command> [flags]
+//
+// Commands:
+//
+//	compact     rewrite the database into a fresh file, reclaiming free space
+//	export      write events as newline-delimited JSON
+//	import      load events from newline-delimited JSON
+//	migrate     copy events from a relayer SQLite3 database
+//	fsck        check the indexes against the stored events
+//	tombstones  clear the tombstones left by deleted events
+//	stats       count the stored events by kind, author and tag
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"time"
+
+	bolt "github.com/lnproxy/boltdb-relayer-storage"
+)
+
+type command struct {
+	name  string
+	usage string
+	run   func(args []string) error
+}
+
+var commands = []command{
+	{"compact", "rewrite the database into a fresh file, reclaiming free space", compact},
+	{"export", "write events as newline-delimited JSON", export},
+	{"import", "load events from newline-delimited JSON", importEvents},
+	{"migrate", "copy events from a relayer SQLite3 database", migrate},
+	{"fsck", "check the indexes against the stored events", fsck},
+	{"tombstones", "clear the tombstones left by deleted events", tombstones},
+	{"stats", "count the stored events by kind, author and tag", stats},
+}
+
+func main() {
+	log.SetFlags(0)
+	log.SetPrefix("boltrelay: ")
+	if len(os.Args) < 2 {
+		usage()
+		os.Exit(2)
+	}
+	for _, c := range commands {
+		if c.name == os.Args[1] {
+			if err := c.run(os.Args[2:]); err != nil {
+				log.Fatal(err)
+			}
+			return
+		}
+	}
+	usage()
+	os.Exit(2)
+}
+
+func usage() {
+	fmt.Fprintln(os.Stderr, "usage: boltrelay <command> [flags]")
+	fmt.Fprintln(os.Stderr, "\ncommands:")
+	for _, c := range commands {
+		fmt.Fprintf(os.Stderr, "  %-12s %s\n", c.name, c.usage)
+	}
+}
+
+// openMode says how a command opens the database.
+type openMode int
+
+const (
+	// openExisting fails if the database doesn't exist.
+	openExisting openMode = iota
+	// openOrCreate creates the database if needed.
+	openOrCreate
+	// openReadOnly opens an existing database read-only, so the command can
+	// run while a relay has it open.
+	openReadOnly
+)
+
+// open parses the common -db flag along with any command specific flags in
+// fs and opens the backend as mode says.
+func open(fs *flag.FlagSet, args []string, mode openMode) (*bolt.BoltBackend, error) {
+	path := fs.String("db", "", "path to the bolt database")
+	if err := fs.Parse(args); err != nil {
+		return nil, err
+	}
+	if *path == "" {
+		fs.Usage()
+		return nil, fmt.Errorf("%s: -db is required", fs.Name())
+	}
+	if _, err := os.Stat(*path); err != nil && mode != openOrCreate {
+		return nil, err
+	}
+	b := &bolt.BoltBackend{DatabaseURL: *path, Options: bolt.Options{ReadOnly: mode == openReadOnly}}
+	if err := b.Init(); err != nil {
+		return nil, err
+	}
+	return b, nil
+}
+
+func fsck(args []string) error {
+	fs := flag.NewFlagSet("fsck", flag.ExitOnError)
+	rebuild := fs.Bool("rebuild", false, "regenerate every index from the stored events")
+	b, err := open(fs, args, openExisting)
+	if err != nil {
+		return err
+	}
+	defer b.Close(context.Background())
+	ctx := context.Background()
+	if *rebuild {
+		if err := b.Rebuild(ctx); err != nil {
+			return err
+		}
+	}
+	r, err := b.Verify(ctx)
+	if err != nil {
+		return err
+	}
+	for _, p := range r.Problems {
+		fmt.Println(p)
+	}
+	fmt.Printf("%d events, %d problems\n", r.Events, r.ProblemCount)
+	if !r.OK() {
+		os.Exit(1)
+	}
+	return nil
+}
+
+func tombstones(args []string) error {
+	fs := flag.NewFlagSet("tombstones", flag.ExitOnError)
+	olderThan := fs.Duration("older-than", 0, "only clear tombstones older than this")
+	b, err := open(fs, args, openExisting)
+	if err != nil {
+		return err
+	}
+	defer b.Close(context.Background())
+	n, err := b.ClearTombstones(*olderThan)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("cleared %d tombstones\n", n)
+	return nil
+}
+
+func compact(args []string) error {
+	fs := flag.NewFlagSet("compact", flag.ExitOnError)
+	b, err := open(fs, args, openExisting)
+	if err != nil {
+		return err
+	}
+	defer b.Close(context.Background())
+	r, err := b.Compact()
+	if err != nil {
+		return err
+	}
+	fmt.Printf("compacted %s in %s: %d -> %d bytes (%d reclaimed)\n",
+		b.DatabaseURL, r.Duration, r.SizeBefore, r.SizeAfter, r.Reclaimed())
+	return nil
+}
+
+func stats(args []string) error {
+	fs := flag.NewFlagSet("stats", flag.ExitOnError)
+	top := fs.Int("top", 10, "number of authors to list")
+	b, err := open(fs, args, openReadOnly)
+	if err != nil {
+		return err
+	}
+	defer b.Close(context.Background())
+	b.StatsTopAuthors = *top
+	r, err := b.Stats(context.Background())
+	if err != nil {
+		return err
+	}
+	fmt.Printf("%d events from %d authors, %d bytes on disk\n", r.Events, r.Authors, r.Size)
+	if r.Events > 0 {
+		fmt.Printf("oldest %s, newest %s\n", r.Oldest.Time().UTC().Format(time.RFC3339), r.Newest.Time().UTC().Format(time.RFC3339))
+	}
+	kinds := make([]int, 0, len(r.Kinds))
+	for k := range r.Kinds {
+		kinds = append(kinds, k)
+	}
+	sort.Ints(kinds)
+	fmt.Println("\nkinds:")
+	for _, k := range kinds {
+		fmt.Printf("  %-8d %d\n", k, r.Kinds[k])
+	}
+	fmt.Println("\ntop authors:")
+	for _, a := range r.TopAuthors {
+		fmt.Printf("  %s %d\n", a.PubKey, a.Events)
+	}
+	letters := make([]string, 0, len(r.TagValues))
+	for l := range r.TagValues {
+		letters = append(letters, l)
+	}
+	sort.Strings(letters)
+	fmt.Println("\ntag values:")
+	for _, l := range letters {
+		fmt.Printf("  %-8s %d\n", l, r.TagValues[l])
+	}
+	return nil
+}
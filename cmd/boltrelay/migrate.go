@@ -0,0 +1,45 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+
+	"github.com/fiatjaf/relayer/v2/storage/sqlite3"
+)
+
+func migrate(args []string) error {
+	fs := flag.NewFlagSet("migrate", flag.ExitOnError)
+	src := fs.String("sqlite3", "", "path to the SQLite3Backend database to copy from")
+	b, err := open(fs, args, openOrCreate)
+	if err != nil {
+		return err
+	}
+	defer b.Close(context.Background())
+	if *src == "" {
+		return errors.New("migrate: -sqlite3 is required")
+	}
+	if _, err := os.Stat(*src); err != nil {
+		return err
+	}
+	sql := &sqlite3.SQLite3Backend{DatabaseURL: *src}
+	if err := sql.Init(); err != nil {
+		return err
+	}
+
+	// interrupting leaves a checkpoint, running again resumes from it
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+	r, err := b.MigrateFrom(ctx, sql, "sqlite3:"+*src)
+	if r != nil {
+		fmt.Printf("%d pages: %d saved, %d duplicate, %d rejected\n",
+			r.Pages, r.Saved, r.Duplicates, r.Rejected)
+		if r.Incomplete > 0 {
+			fmt.Printf("%d seconds held more events than the source returns at once and may be incomplete\n", r.Incomplete)
+		}
+	}
+	return err
+}
@@ -0,0 +1,185 @@
+package bolt
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// compactTxMaxSize bounds the size of each write transaction used to fill
+// the compacted database.
+const compactTxMaxSize = 64 << 20
+
+// CompactReport describes the result of a compaction.
+type CompactReport struct {
+	SizeBefore int64
+	SizeAfter  int64
+	Duration   time.Duration
+}
+
+// Reclaimed returns the number of bytes freed by the compaction.
+func (r *CompactReport) Reclaimed() int64 {
+	return r.SizeBefore - r.SizeAfter
+}
+
+// Compact rewrites the database into a fresh, tightly packed file, checks
+// the copy and atomically swaps it in for the current one, reclaiming the
+// space left behind by deleted events. Writes block while the copy is made;
+// reads never block, those running during the swap finish on the previous
+// file.
+func (b *BoltBackend) Compact() (*CompactReport, error) {
+	if b.Options.ReadOnly {
+		return nil, ErrReadOnly
+	}
+	done, err := b.begin()
+	if err != nil {
+		return nil, err
+	}
+	defer done()
+	start := time.Now()
+	b.writeMu.Lock()
+	defer b.writeMu.Unlock()
+
+	r := &CompactReport{}
+	if r.SizeBefore, err = b.fileSize(); err != nil {
+		return nil, err
+	}
+
+	path := b.DatabaseURL + ".compact"
+	os.Remove(path)
+	dst, err := b.openDB(path, false)
+	if err != nil {
+		return nil, err
+	}
+	err = b.DB.View(func(tx *bolt.Tx) error {
+		if err := copyBuckets(dst, tx, compactTxMaxSize); err != nil {
+			return err
+		}
+		return verifyCopy(dst, tx)
+	})
+	if err != nil {
+		dst.Close()
+		os.Remove(path)
+		return nil, err
+	}
+	if err := dst.Close(); err != nil {
+		os.Remove(path)
+		return nil, err
+	}
+
+	if err := b.swapDB(path); err != nil {
+		return nil, err
+	}
+	if r.SizeAfter, err = b.fileSize(); err != nil {
+		return nil, err
+	}
+	r.Duration = time.Since(start)
+	return r, nil
+}
+
+// copyBuckets copies every bucket of src into dst, committing whenever
+// txMaxSize bytes have been written. Buckets are filled completely since
+// keys are inserted in order.
+func copyBuckets(dst *bolt.DB, src *bolt.Tx, txMaxSize int64) error {
+	tx, err := dst.Begin(true)
+	if err != nil {
+		return err
+	}
+	defer func() { tx.Rollback() }()
+
+	var size int64
+	var walk func(path [][]byte, sb *bolt.Bucket) error
+	walk = func(path [][]byte, sb *bolt.Bucket) error {
+		return sb.ForEach(func(k, v []byte) error {
+			if sz := int64(len(k) + len(v)); size+sz > txMaxSize {
+				if err := tx.Commit(); err != nil {
+					return err
+				}
+				if tx, err = dst.Begin(true); err != nil {
+					return err
+				}
+				size = 0
+			}
+			size += int64(len(k) + len(v))
+			db := tx.Bucket(path[0])
+			db.FillPercent = 1
+			for _, name := range path[1:] {
+				db = db.Bucket(name)
+				db.FillPercent = 1
+			}
+			if v != nil {
+				return db.Put(k, v)
+			}
+			nb, err := db.CreateBucket(k)
+			if err != nil {
+				return err
+			}
+			nsb := sb.Bucket(k)
+			if err := nb.SetSequence(nsb.Sequence()); err != nil {
+				return err
+			}
+			return walk(append(append([][]byte(nil), path...), k), nsb)
+		})
+	}
+
+	err = src.ForEach(func(name []byte, sb *bolt.Bucket) error {
+		b, err := tx.CreateBucket(name)
+		if err != nil {
+			return err
+		}
+		if err := b.SetSequence(sb.Sequence()); err != nil {
+			return err
+		}
+		return walk([][]byte{name}, sb)
+	})
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// verifyCopy runs bolt's consistency check on dst and makes sure every top
+// level bucket holds as many keys and buckets as it does in src.
+func verifyCopy(dst *bolt.DB, src *bolt.Tx) error {
+	return dst.View(func(tx *bolt.Tx) error {
+		for err := range tx.Check() {
+			return fmt.Errorf("compacted database failed check: %w", err)
+		}
+		return src.ForEach(func(name []byte, sb *bolt.Bucket) error {
+			db := tx.Bucket(name)
+			if db == nil {
+				return fmt.Errorf("compacted database is missing bucket %q", name)
+			}
+			ss, ds := sb.Stats(), db.Stats()
+			if ss.KeyN != ds.KeyN || ss.BucketN != ds.BucketN {
+				return errors.New("compacted database differs from the original in bucket " + string(name))
+			}
+			return nil
+		})
+	})
+}
+
+// swapDB moves the file at path over the database, opens it and makes it
+// the current database. The previous file stays open for the reads still
+// using it. The caller must hold writeMu.
+func (b *BoltBackend) swapDB(path string) error {
+	if err := os.Rename(path, b.DatabaseURL); err != nil {
+		os.Remove(path)
+		return err
+	}
+	db, err := b.openDB(b.DatabaseURL, false)
+	if err != nil {
+		return err
+	}
+	old := b.DB
+	db.MaxBatchSize = old.MaxBatchSize
+	db.MaxBatchDelay = old.MaxBatchDelay
+	db.AllocSize = old.AllocSize
+	db.NoSync = old.NoSync
+	db.NoGrowSync = old.NoGrowSync
+	b.replaceDB(db)
+	return nil
+}
@@ -0,0 +1,129 @@
+package bolt
+
+import (
+	"context"
+	"encoding/hex"
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/fiatjaf/relayer/v2"
+	"github.com/nbd-wtf/go-nostr"
+	bolt "go.etcd.io/bbolt"
+)
+
+func TestCompact(t *testing.T) {
+	f, _ := os.CreateTemp("", "")
+	f.Close()
+	defer os.Remove(f.Name())
+	s := &BoltBackend{DatabaseURL: f.Name()}
+	s.Init()
+	s.DB.MaxBatchSize = 0
+
+	ctx := context.Background()
+	n := 5000
+	ids, _, _ := setupStorage([]relayer.Storage{s}, n)
+	err := s.update(func(tx *bolt.Tx) error {
+		for _, id := range ids[:n-100] {
+			idb, _ := hex.DecodeString(id)
+			if err := s.deleteEvent(tx, idb); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// queries keep working while the database is compacted
+	var wg sync.WaitGroup
+	done := make(chan struct{})
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for {
+			select {
+			case <-done:
+				return
+			default:
+			}
+			ch, _ := s.QueryEvents(ctx, &nostr.Filter{IDs: []string{ids[n-1]}})
+			for range ch {
+			}
+		}
+	}()
+	r, err := s.Compact()
+	close(done)
+	wg.Wait()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.Reclaimed() <= 0 {
+		t.Error("no space reclaimed", r)
+	}
+
+	ch, _ := s.QueryEvents(ctx, &nostr.Filter{Limit: 100})
+	var i int
+	for range ch {
+		i++
+	}
+	if i != 100 {
+		t.Error("unexpected number of events", i)
+	}
+
+	e := nostr.Event{
+		ID:        randHex(32),
+		PubKey:    randHex(32),
+		CreatedAt: nostr.Timestamp(int64(n)),
+		Kind:      1,
+		Content:   "arbitrary string",
+		Sig:       randHex(64),
+	}
+	if err := s.SaveEvent(ctx, &e); err != nil {
+		t.Error("save after compaction failed", err)
+	}
+}
+
+func TestCompactWithAbandonedQuery(t *testing.T) {
+	f, _ := os.CreateTemp("", "")
+	f.Close()
+	defer os.Remove(f.Name())
+	s := &BoltBackend{DatabaseURL: f.Name()}
+	s.Init()
+	s.DB.MaxBatchSize = 0
+
+	ctx := context.Background()
+	for i := 0; i < 10; i++ {
+		e := nostr.Event{ID: randHex(32), PubKey: randHex(32), CreatedAt: nostr.Timestamp(i), Kind: 1, Sig: randHex(64)}
+		if err := s.SaveEvent(ctx, &e); err != nil {
+			t.Fatal(err)
+		}
+	}
+	ch, _ := s.QueryEvents(ctx, &nostr.Filter{Kinds: []int{1}})
+	<-ch
+
+	compacted := make(chan error, 1)
+	go func() {
+		_, err := s.Compact()
+		compacted <- err
+	}()
+	select {
+	case err := <-compacted:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Compact waited for an abandoned query")
+	}
+	ch2, _ := s.QueryEvents(ctx, &nostr.Filter{Kinds: []int{1}})
+	if n := len(collect(ch2)); n != 10 {
+		t.Errorf("got %d events after compacting, want 10", n)
+	}
+	if err := s.Close(ctx); err != nil {
+		t.Fatal(err)
+	}
+	for range ch {
+	}
+}
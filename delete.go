@@ -5,86 +5,135 @@ import (
 	"context"
 	"encoding/gob"
 	"encoding/hex"
-	"errors"
+	"fmt"
+	"time"
 
 	"github.com/nbd-wtf/go-nostr"
 	bolt "go.etcd.io/bbolt"
 )
 
 func (b *BoltBackend) DeleteEvent(ctx context.Context, id string, pubkey string) error {
-	idb, _ := hex.DecodeString(id)
-	pubkeyb, _ := hex.DecodeString(pubkey)
+	_, err := b.deleteOwnedEvent(ctx, id, pubkey)
+	return err
+}
 
-	return b.DB.Batch(func(tx *bolt.Tx) error {
-		timestamps := tx.Bucket([]byte("timestamps"))
-		timestamp_id := timestamps.Get(idb)
-		if timestamp_id == nil {
-			return errors.New("no timestamp")
-		}
-		timestamp_id = append(timestamp_id, idb...)
+// deleteOwnedEvent is DeleteEvent returning the deleted event, so that its
+// tombstones can be copied elsewhere.
+func (b *BoltBackend) deleteOwnedEvent(ctx context.Context, id string, pubkey string) (deleted *nostr.Event, err error) {
+	defer func() { b.metrics.countDelete(err) }()
+	if b.Options.ReadOnly {
+		return nil, ErrReadOnly
+	}
+	idb, err := hex.DecodeString(id)
+	if err != nil || len(idb) != 32 {
+		return nil, ErrEventNotFound
+	}
+	// compare keys as bytes, so that hex of either case owns the event
+	pubkeyb, err := hex.DecodeString(pubkey)
+	if err != nil || len(pubkeyb) != 32 {
+		return nil, ErrNotOwner
+	}
 
-		authors := tx.Bucket([]byte("authors"))
-		author := authors.Bucket(pubkeyb)
-		if author == nil || author.Get(timestamp_id) == nil {
-			return nil
+	err = b.batch(func(tx *bolt.Tx) error {
+		deleted = nil
+		v := tx.Bucket([]byte("events")).Get(idb)
+		if v == nil {
+			if tx.Bucket([]byte("timestamps")).Get(idb) != nil {
+				return fmt.Errorf("%w: %s is indexed but not stored", ErrCorruptIndex, id)
+			}
+			return ErrEventNotFound
 		}
-
-		events := tx.Bucket([]byte("events"))
-		v := events.Get(idb)
 		e := nostr.Event{}
-		gob.NewDecoder(bytes.NewBuffer(v)).Decode(&e)
-		idx := makeEventIndexBytes(&e)
-
-		if err := events.Delete(idx.ID); err != nil {
-			return err
+		if err := gob.NewDecoder(bytes.NewBuffer(v)).Decode(&e); err != nil {
+			return fmt.Errorf("%w: %s can't be decoded: %v", ErrCorruptIndex, id, err)
 		}
-
-		if err := timestamps.Delete(idx.ID); err != nil {
-			return err
+		if owner, _ := hex.DecodeString(e.PubKey); !bytes.Equal(owner, pubkeyb) {
+			return ErrNotOwner
 		}
 
-		timestamp_ids := tx.Bucket([]byte("timestamp_ids"))
-		if err := timestamp_ids.Delete(idx.TimestampID); err != nil {
+		if err := b.deleteEvent(tx, idb); err != nil {
 			return err
 		}
+		deleted = &e
+		return putTombstones(tx, &e, pubkeyb, time.Now())
+	})
+	if err != nil {
+		return nil, err
+	}
+	return deleted, nil
+}
 
-		if err := author.Delete(idx.TimestampID); err != nil {
-			return err
-		}
-		if k, _ := author.Cursor().First(); k == nil {
-			if err := authors.DeleteBucket(idx.PubKey); err != nil {
-				return err
-			}
-		}
+// deleteEvent removes the event with the given id from the events bucket and
+// every index that references it. Index buckets that are already missing
+// are skipped.
+func (b *BoltBackend) deleteEvent(tx *bolt.Tx, idb []byte) error {
+	events := tx.Bucket([]byte("events"))
+	v := events.Get(idb)
+	if v == nil {
+		return ErrEventNotFound
+	}
+	e := nostr.Event{}
+	if err := gob.NewDecoder(bytes.NewBuffer(v)).Decode(&e); err != nil {
+		return fmt.Errorf("%w: %x can't be decoded: %v", ErrCorruptIndex, idb, err)
+	}
+	idx := makeEventIndexBytes(&e)
+	if !bytes.Equal(idx.ID, idb) {
+		return fmt.Errorf("%w: %x is stored as %s", ErrCorruptIndex, idb, e.ID)
+	}
 
-		kinds := tx.Bucket([]byte("kinds"))
-		kind := kinds.Bucket(idx.Kind)
-		if err := kind.Delete(idx.TimestampID); err != nil {
-			return err
+	if err := events.Delete(idx.ID); err != nil {
+		return err
+	}
+	if _, underflow, err := addUsage(tx, idx.PubKey, -1, -int64(len(v))); err != nil {
+		return err
+	} else if underflow {
+		b.logf("usage of %s was lower than its event %s, run Rebuild to recount it", e.PubKey, e.ID)
+	}
+
+	timestamps := tx.Bucket([]byte("timestamps"))
+	if err := timestamps.Delete(idx.ID); err != nil {
+		return err
+	}
+
+	timestamp_ids := tx.Bucket([]byte("timestamp_ids"))
+	if err := timestamp_ids.Delete(idx.TimestampID); err != nil {
+		return err
+	}
+
+	if err := deleteIndexEntry(tx.Bucket([]byte("authors")), idx.PubKey, idx.TimestampID); err != nil {
+		return err
+	}
+
+	if err := deleteIndexEntry(tx.Bucket([]byte("kinds")), idx.Kind, idx.TimestampID); err != nil {
+		return err
+	}
+
+	for tagKey, tagValues := range idx.Tags {
+		tagBucket := tx.Bucket([]byte(tagKey))
+		if tagBucket == nil {
+			continue
 		}
-		if k, _ := kind.Cursor().First(); k == nil {
-			if err := kinds.DeleteBucket(idx.Kind); err != nil {
+		for _, tagValue := range tagValues {
+			if err := deleteIndexEntry(tagBucket, tagValue, idx.TimestampID); err != nil {
 				return err
 			}
 		}
+	}
+	return nil
+}
 
-		for tagKey, tagValues := range idx.Tags {
-			if len(tagValues) == 0 {
-				continue
-			}
-			tagBucket := tx.Bucket([]byte(tagKey))
-			for _, tagValue := range tagValues {
-				tagSubBucket := tagBucket.Bucket(tagValue)
-				if err := tagSubBucket.Delete(idx.TimestampID); err != nil {
-					return err
-				}
-				if k, _ := tagSubBucket.Cursor().First(); k == nil {
-					if err := tagBucket.DeleteBucket(tagValue); err != nil {
-						return err
-					}
-				}
-			}
-		}
+// deleteIndexEntry deletes key from the sub-bucket name of parent, dropping
+// the sub-bucket once it is empty. A missing sub-bucket is not an error.
+func deleteIndexEntry(parent *bolt.Bucket, name, key []byte) error {
+	sub := parent.Bucket(name)
+	if sub == nil {
 		return nil
-	})
+	}
+	if err := sub.Delete(key); err != nil {
+		return err
+	}
+	if k, _ := sub.Cursor().First(); k == nil {
+		return parent.DeleteBucket(name)
+	}
+	return nil
 }
@@ -0,0 +1,244 @@
+package bolt
+
+import (
+	"context"
+	"encoding/hex"
+	"errors"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/fiatjaf/relayer/v2"
+	"github.com/nbd-wtf/go-nostr"
+	bolt "go.etcd.io/bbolt"
+)
+
+func TestDeleteEvent(t *testing.T) {
+	f, _ := os.CreateTemp("", "")
+	f.Close()
+	defer os.Remove(f.Name())
+	s := &BoltBackend{DatabaseURL: f.Name()}
+	s.Init()
+	s.DB.MaxBatchSize = 0
+
+	ctx := context.Background()
+	e := nostr.Event{
+		ID:        randHex(32),
+		PubKey:    randHex(32),
+		CreatedAt: nostr.Timestamp(1000),
+		Kind:      1,
+		Tags:      nostr.Tags{nostr.Tag{"p", randHex(32)}, nostr.Tag{"e", randHex(32)}},
+		Content:   "arbitrary string",
+		Sig:       randHex(64),
+	}
+	s.SaveEvent(ctx, &e)
+	setupStorage([]relayer.Storage{s}, 100)
+
+	if err := s.DeleteEvent(ctx, e.ID, randHex(32)); err != ErrNotOwner {
+		t.Error("expected ErrNotOwner", err)
+	}
+	if n := countEvents(s, &nostr.Filter{IDs: []string{e.ID}}); n != 1 {
+		t.Error("event deleted by another pubkey")
+	}
+
+	if err := s.DeleteEvent(ctx, e.ID, "not hex"); err != ErrNotOwner {
+		t.Error("expected ErrNotOwner", err)
+	}
+	// the owner may spell its pubkey in uppercase hex
+	if err := s.DeleteEvent(ctx, e.ID, strings.ToUpper(e.PubKey)); err != nil {
+		t.Error(err)
+	}
+	for _, filter := range []*nostr.Filter{
+		{IDs: []string{e.ID}},
+		{Authors: []string{e.PubKey}},
+		{Kinds: []int{1}, Tags: nostr.TagMap{"p": []string{e.Tags[0][1]}}},
+		{Tags: nostr.TagMap{"e": []string{e.Tags[1][1]}}},
+	} {
+		if n := countEvents(s, filter); n != 0 {
+			t.Error("deleted event still returned", filter)
+		}
+	}
+
+	if err := s.DeleteEvent(ctx, e.ID, e.PubKey); err != ErrEventNotFound {
+		t.Error("expected ErrEventNotFound", err)
+	}
+	if err := s.DeleteEvent(ctx, "abc", e.PubKey); err != ErrEventNotFound {
+		t.Error("expected ErrEventNotFound", err)
+	}
+
+	r, err := s.Verify(ctx)
+	if err != nil || !r.OK() || r.Events != 100 {
+		t.Error("unexpected problems after delete", r.Problems, err)
+	}
+	s.DB.View(func(tx *bolt.Tx) error {
+		pubkeyb, _ := hex.DecodeString(e.PubKey)
+		if tx.Bucket([]byte("authors")).Bucket(pubkeyb) != nil {
+			t.Error("author index not cleaned up")
+		}
+		if tb := tx.Bucket([]byte("e")); tb != nil && tb.Bucket([]byte(e.Tags[1][1])) != nil {
+			t.Error("tag index not cleaned up")
+		}
+		return nil
+	})
+}
+
+func TestDeleteEventCorrupted(t *testing.T) {
+	f, _ := os.CreateTemp("", "")
+	f.Close()
+	defer os.Remove(f.Name())
+	s := &BoltBackend{DatabaseURL: f.Name()}
+	s.Init()
+	s.DB.MaxBatchSize = 0
+
+	ctx := context.Background()
+	e := nostr.Event{
+		ID:        randHex(32),
+		PubKey:    randHex(32),
+		CreatedAt: nostr.Timestamp(1000),
+		Kind:      1,
+		Tags:      nostr.Tags{nostr.Tag{"p", "x"}, nostr.Tag{"p", "x"}},
+		Content:   "arbitrary string",
+		Sig:       randHex(64),
+	}
+	s.SaveEvent(ctx, &e)
+	idb, _ := hex.DecodeString(e.ID)
+
+	// drop the kind index and the tag bucket from under the event
+	s.DB.Update(func(tx *bolt.Tx) error {
+		tx.DeleteBucket([]byte("p"))
+		return tx.Bucket([]byte("kinds")).DeleteBucket(makeEventIndexBytes(&e).Kind)
+	})
+	if err := s.DeleteEvent(ctx, e.ID, e.PubKey); err != nil {
+		t.Error("delete with missing index buckets failed", err)
+	}
+
+	s.DB.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket([]byte("timestamps")).Put(idb, make([]byte, 8))
+	})
+	if err := s.DeleteEvent(ctx, e.ID, e.PubKey); !errors.Is(err, ErrCorruptIndex) {
+		t.Error("expected ErrCorruptIndex", err)
+	}
+
+	s.DB.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket([]byte("events")).Put(idb, []byte("garbage"))
+	})
+	if err := s.DeleteEvent(ctx, e.ID, e.PubKey); !errors.Is(err, ErrCorruptIndex) {
+		t.Error("expected ErrCorruptIndex", err)
+	}
+}
+
+func TestTombstones(t *testing.T) {
+	f, _ := os.CreateTemp("", "")
+	f.Close()
+	defer os.Remove(f.Name())
+	s := &BoltBackend{DatabaseURL: f.Name()}
+	s.Init()
+	s.DB.MaxBatchSize = 0
+
+	ctx := context.Background()
+	now := time.Now().Unix()
+	e := nostr.Event{
+		ID:        randHex(32),
+		PubKey:    randHex(32),
+		CreatedAt: nostr.Timestamp(now - 10),
+		Kind:      30023,
+		Tags:      nostr.Tags{nostr.Tag{"d", "article"}},
+		Content:   "arbitrary string",
+		Sig:       randHex(64),
+	}
+	s.SaveEvent(ctx, &e)
+	if err := s.DeleteEvent(ctx, e.ID, e.PubKey); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.SaveEvent(ctx, &e); err != ErrDeleted {
+		t.Error("deleted event saved again", err)
+	}
+
+	older := e
+	older.ID = randHex(32)
+	older.CreatedAt -= 10
+	if err := s.SaveEvent(ctx, &older); err != ErrDeleted {
+		t.Error("older version of deleted address saved", err)
+	}
+	newer := e
+	newer.ID = randHex(32)
+	newer.CreatedAt = nostr.Timestamp(now + 10)
+	if err := s.SaveEvent(ctx, &newer); err != nil {
+		t.Error("newer version of deleted address rejected", err)
+	}
+
+	if n, err := s.ClearTombstones(time.Hour); err != nil || n != 0 {
+		t.Error("cleared recent tombstones", n, err)
+	}
+	if n, err := s.ClearTombstones(0); err != nil || n != 2 {
+		t.Error("unexpected number of tombstones cleared", n, err)
+	}
+	if err := s.SaveEvent(ctx, &e); err != nil {
+		t.Error("event rejected after clearing tombstones", err)
+	}
+
+	s.TombstoneLifetime = time.Nanosecond
+	s.DeleteEvent(ctx, e.ID, e.PubKey)
+	time.Sleep(time.Second)
+	if err := s.SaveEvent(ctx, &e); err != nil {
+		t.Error("event rejected after tombstone expired", err)
+	}
+}
+
+func TestMigrateTimestamps(t *testing.T) {
+	f, _ := os.CreateTemp("", "")
+	f.Close()
+	defer os.Remove(f.Name())
+	s := &BoltBackend{DatabaseURL: f.Name()}
+	s.Init()
+	s.DB.MaxBatchSize = 0
+
+	ctx := context.Background()
+	ids, _, _ := setupStorage([]relayer.Storage{s}, 100)
+
+	// rewrite the database the way version 1 left it
+	s.DB.Update(func(tx *bolt.Tx) error {
+		timestamps := tx.Bucket([]byte("timestamps"))
+		timestamps.ForEach(func(k, _ []byte) error {
+			return timestamps.Put(k, nil)
+		})
+		return writeSchemaVersion(tx, 1)
+	})
+	s.DB.Close()
+
+	s = &BoltBackend{DatabaseURL: f.Name()}
+	if err := s.Init(); err != nil {
+		t.Fatal(err)
+	}
+	s.DB.MaxBatchSize = 0
+	s.DB.View(func(tx *bolt.Tx) error {
+		if v, _ := readSchemaVersion(tx); v != schemaVersion {
+			t.Error("schema not migrated", v)
+		}
+		return nil
+	})
+	if r, err := s.Verify(ctx); err != nil || !r.OK() {
+		t.Error("unexpected problems after migration", r.Problems, err)
+	}
+
+	ch, _ := s.QueryEvents(ctx, &nostr.Filter{IDs: ids[:1]})
+	e := <-ch
+	for range ch {
+	}
+	if err := s.DeleteEvent(ctx, e.ID, e.PubKey); err != nil {
+		t.Error(err)
+	}
+	if n := countEvents(s, &nostr.Filter{IDs: ids[:1]}); n != 0 {
+		t.Error("event not deleted after migration")
+	}
+}
+
+func countEvents(s relayer.Storage, filter *nostr.Filter) int {
+	ch, _ := s.QueryEvents(context.Background(), filter)
+	var n int
+	for range ch {
+		n++
+	}
+	return n
+}
@@ -0,0 +1,57 @@
+package bolt
+
+import (
+	"errors"
+
+	"github.com/fiatjaf/relayer/v2/storage"
+)
+
+// ErrDupEvent is returned by SaveEvent when the event is already stored.
+// It is the same value as relayer's storage.ErrDupEvent so the relay can
+// report the duplicate to the client.
+var ErrDupEvent = storage.ErrDupEvent
+
+// ErrInvalidEvent is wrapped by the errors SaveEvent returns for events that
+// fail verification when BoltBackend.VerifyEvents is set.
+var ErrInvalidEvent = errors.New("invalid: event failed verification")
+
+// Errors returned by DeleteEvent.
+var (
+	ErrEventNotFound = errors.New("invalid: event not found")
+	ErrNotOwner      = errors.New("blocked: event belongs to another pubkey")
+	ErrCorruptIndex  = errors.New("error: corrupted index")
+)
+
+// ErrDeleted is returned by SaveEvent for events that have been deleted with
+// DeleteEvent, until their tombstone expires.
+var ErrDeleted = errors.New("blocked: event has been deleted")
+
+// ErrBlocked is returned by SaveEvent for events whose pubkey, id or content
+// is on the blocklist.
+var ErrBlocked = errors.New("blocked: event is on the blocklist")
+
+// ErrReadOnly is returned by SaveEvent, DeleteEvent and every other write
+// when the backend was opened with Options.ReadOnly.
+var ErrReadOnly = errors.New("error: database is read-only")
+
+// ErrClosed is returned by every operation started after Close.
+var ErrClosed = errors.New("error: database is closed")
+
+// ErrOutOfRange is returned by ShardedBackend.SaveEvent for events created
+// too long ago or too far in the future.
+var ErrOutOfRange = errors.New("invalid: created_at is out of the accepted range")
+
+// ErrDropping is returned by ShardedBackend.SaveEvent for events belonging
+// to a partition that DropBefore is deleting.
+var ErrDropping = errors.New("error: partition is being dropped")
+
+// ErrUsageUnderflow is returned by AdjustAuthorUsage for deltas that would
+// take an author's usage below zero.
+var ErrUsageUnderflow = errors.New("error: usage can't go below zero")
+
+// ErrDatabaseFull is returned by SaveEvent while the database is at MaxSize.
+var ErrDatabaseFull = errors.New("error: database is full")
+
+// ErrMaxSizeUnreachable is returned by EnforceMaxSize when the protected
+// events alone don't fit under MaxSize.
+var ErrMaxSizeUnreachable = errors.New("error: protected events exceed the maximum size")
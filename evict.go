@@ -0,0 +1,182 @@
+package bolt
+
+import (
+	"bytes"
+	"context"
+	"encoding/gob"
+	"encoding/hex"
+	"errors"
+	"os"
+
+	"github.com/nbd-wtf/go-nostr"
+	bolt "go.etcd.io/bbolt"
+)
+
+const (
+	// Eviction starts once the database reaches evictHighWater of MaxSize
+	// and stops once the live data fits in evictLowWater of MaxSize.
+	evictHighWater = 0.9
+	evictLowWater  = 0.8
+	// evictBatchSize bounds the number of events deleted per write
+	// transaction.
+	evictBatchSize = 1000
+)
+
+// maybeEvict starts EnforceMaxSize in the background when size, the size of
+// the database as seen by the last write, is close to MaxSize and no
+// eviction is already running.
+func (b *BoltBackend) maybeEvict(size int64) {
+	if b.MaxSize <= 0 || float64(size) < evictHighWater*float64(b.MaxSize) {
+		return
+	}
+	if !b.evicting.CompareAndSwap(false, true) {
+		return
+	}
+	ctx, done, err := b.beginCancelable(context.Background())
+	if err != nil {
+		b.evicting.Store(false)
+		return
+	}
+	go func() {
+		defer done()
+		defer b.evicting.Store(false)
+		// ErrMaxSizeUnreachable is logged once by EnforceMaxSize
+		if _, err := b.EnforceMaxSize(ctx); err != nil && ctx.Err() == nil && !errors.Is(err, ErrMaxSizeUnreachable) {
+			b.logf("eviction failed: %v", err)
+		}
+	}()
+}
+
+func (b *BoltBackend) fileSize() (int64, error) {
+	fi, err := os.Stat(b.DatabaseURL)
+	if err != nil {
+		return 0, err
+	}
+	return fi.Size(), nil
+}
+
+// usedSize estimates the bytes held by live pages, excluding free pages that
+// a compaction would reclaim.
+func (b *BoltBackend) usedSize() (used int64, err error) {
+	err = b.view(func(tx *bolt.Tx) error {
+		stats := tx.DB().Stats()
+		free := int64(stats.FreePageN+stats.PendingPageN) * int64(tx.DB().Info().PageSize)
+		used = tx.Size() - free
+		return nil
+	})
+	return
+}
+
+// EnforceMaxSize evicts the oldest unprotected events until the live data
+// fits comfortably under MaxSize and then compacts the database if the file
+// is still too large. It returns the number of events evicted.
+//
+// If the protected events alone don't fit it fails with
+// ErrMaxSizeUnreachable, and keeps failing without evicting or compacting
+// anything until the live data shrinks, so saves above the high-water mark
+// don't each start a compaction that can't help.
+func (b *BoltBackend) EnforceMaxSize(ctx context.Context) (int, error) {
+	if b.MaxSize <= 0 {
+		return 0, nil
+	}
+	if stuck := b.evictStuck.Load(); stuck > 0 {
+		used, err := b.usedSize()
+		if err != nil {
+			return 0, err
+		}
+		if used >= stuck {
+			return 0, ErrMaxSizeUnreachable
+		}
+		b.evictStuck.Store(0)
+	}
+	protectedKinds := make(map[int]struct{}, len(b.EvictProtectedKinds))
+	for _, k := range b.EvictProtectedKinds {
+		protectedKinds[k] = struct{}{}
+	}
+	protectedPubKeys := make(map[string]struct{}, len(b.EvictProtectedPubKeys))
+	for _, p := range b.EvictProtectedPubKeys {
+		protectedPubKeys[p] = struct{}{}
+	}
+	protected := len(protectedKinds) > 0 || len(protectedPubKeys) > 0
+
+	var evicted int
+	var after []byte
+	var exhausted bool
+	for {
+		if err := ctx.Err(); err != nil {
+			return evicted, err
+		}
+		used, err := b.usedSize()
+		if err != nil {
+			return evicted, err
+		}
+		if float64(used) <= evictLowWater*float64(b.MaxSize) {
+			break
+		}
+		var n int
+		err = b.update(func(tx *bolt.Tx) error {
+			events := tx.Bucket([]byte("events"))
+			c := tx.Bucket([]byte("timestamp_ids")).Cursor()
+			var victims [][]byte
+			k, _ := c.First()
+			if after != nil {
+				k, _ = c.Seek(after)
+			}
+			for ; k != nil && len(victims) < evictBatchSize; k, _ = c.Next() {
+				after = append(after[:0], k...)
+				if protected {
+					evt := nostr.Event{}
+					gob.NewDecoder(bytes.NewBuffer(events.Get(k[8:]))).Decode(&evt)
+					if _, ok := protectedKinds[evt.Kind]; ok {
+						continue
+					}
+					if _, ok := protectedPubKeys[evt.PubKey]; ok {
+						continue
+					}
+				}
+				victims = append(victims, append([]byte(nil), k[8:]...))
+			}
+			for _, id := range victims {
+				err := b.deleteEvent(tx, id)
+				if errors.Is(err, ErrEventNotFound) || errors.Is(err, ErrCorruptIndex) {
+					b.logf("eviction skipped event %s: %v", hex.EncodeToString(id), err)
+					continue
+				}
+				if err != nil {
+					return err
+				}
+				n++
+			}
+			return nil
+		})
+		if err != nil {
+			return evicted, err
+		}
+		evicted += n
+		if n == 0 {
+			// everything left is protected
+			exhausted = true
+			break
+		}
+	}
+
+	size, err := b.fileSize()
+	if err != nil {
+		return evicted, err
+	}
+	if size > b.MaxSize || (evicted > 0 && float64(size) >= evictHighWater*float64(b.MaxSize)) {
+		if _, err := b.Compact(); err != nil {
+			return evicted, err
+		}
+	}
+	if exhausted {
+		used, err := b.usedSize()
+		if err != nil {
+			return evicted, err
+		}
+		b.evictStuck.Store(used)
+		b.logf("max size of %d bytes can't be met: %d bytes are still used with only protected events left", b.MaxSize, used)
+		return evicted, ErrMaxSizeUnreachable
+	}
+	return evicted, nil
+}
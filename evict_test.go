@@ -0,0 +1,81 @@
+package bolt
+
+import (
+	"context"
+	"os"
+	"testing"
+
+	"github.com/nbd-wtf/go-nostr"
+)
+
+func TestEnforceMaxSize(t *testing.T) {
+	f, _ := os.CreateTemp("", "")
+	f.Close()
+	defer os.Remove(f.Name())
+	s := &BoltBackend{DatabaseURL: f.Name(), EvictProtectedKinds: []int{0}}
+	s.Init()
+	s.DB.MaxBatchSize = 0
+
+	ctx := context.Background()
+	n := 5000
+	for i := 0; i < n; i++ {
+		e := nostr.Event{
+			ID:        randHex(32),
+			PubKey:    randHex(32),
+			CreatedAt: nostr.Timestamp(int64(i)),
+			Kind:      i % 10,
+			Tags:      nostr.Tags{nostr.Tag{"p", randHex(32)}},
+			Content:   "arbitrary string",
+			Sig:       randHex(64),
+		}
+		s.SaveEvent(ctx, &e)
+	}
+
+	before, _ := s.fileSize()
+	s.MaxSize = before / 2
+	evicted, err := s.EnforceMaxSize(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	after, _ := s.fileSize()
+	if evicted == 0 || after >= before {
+		t.Error("nothing reclaimed", before, after, evicted)
+	}
+
+	ch, _ := s.QueryEvents(ctx, &nostr.Filter{Kinds: []int{0}, Limit: 100})
+	var i int
+	for range ch {
+		i++
+	}
+	if i != 100 {
+		t.Error("protected events evicted", i)
+	}
+
+	since := nostr.Timestamp(int64(n - 100))
+	ch, _ = s.QueryEvents(ctx, &nostr.Filter{Since: &since})
+	i = 0
+	for range ch {
+		i++
+	}
+	if i != 100 {
+		t.Error("newest events evicted", i)
+	}
+
+	// the protected events alone don't fit: eviction reports it once and
+	// later calls don't compact again
+	s.MaxSize = after / 10
+	if _, err := s.EnforceMaxSize(ctx); err != ErrMaxSizeUnreachable {
+		t.Fatalf("got %v with protected events over the limit, want ErrMaxSizeUnreachable", err)
+	}
+	compacted, _ := os.Stat(f.Name())
+	if _, err := s.EnforceMaxSize(ctx); err != ErrMaxSizeUnreachable {
+		t.Errorf("got %v enforcing an unreachable limit again, want ErrMaxSizeUnreachable", err)
+	}
+	if fi, _ := os.Stat(f.Name()); !os.SameFile(fi, compacted) {
+		t.Error("compacted again although nothing could be evicted")
+	}
+	e := nostr.Event{ID: randHex(32), PubKey: randHex(32), CreatedAt: nostr.Timestamp(int64(n)), Kind: 1, Sig: randHex(64)}
+	if err := s.SaveEvent(ctx, &e); err != ErrDatabaseFull {
+		t.Errorf("got %v saving past MaxSize, want ErrDatabaseFull", err)
+	}
+}
@@ -0,0 +1,87 @@
+package bolt
+
+import (
+	"context"
+
+	"github.com/nbd-wtf/go-nostr"
+	bolt "go.etcd.io/bbolt"
+)
+
+// Explanation describes how a query was planned and run.
+type Explanation struct {
+	// QueryStats holds the plan chosen and what running it cost.
+	QueryStats
+	// Buckets lists the buckets read by the plan, index sub-buckets being
+	// named after their parent, as in "kinds/1" or "p/<pubkey>".
+	Buckets []string
+	// EstimatedKeys is the number of keys in the buckets used, an upper
+	// bound for the keys visited that ignores since, until and limit.
+	EstimatedKeys int
+	// AndSeeks counts the seeks the and cursor made into the cursors of
+	// each condition, including the query's initial seek. OrSeeks counts
+	// the seeks or cursors made into index buckets when a condition lists
+	// several values.
+	AndSeeks int
+	OrSeeks  int
+}
+
+// Explain runs filter like QueryEvents, discarding the events, and
+// describes how the query went.
+func (b *BoltBackend) Explain(filter *nostr.Filter) (*Explanation, error) {
+	if _, err := checkFilter(filter); err != nil {
+		return nil, err
+	}
+	ex := &Explanation{}
+	ch, err := b.queryEvents(context.Background(), filter, nil, &ex.QueryStats, ex)
+	if err != nil {
+		return nil, err
+	}
+	for range ch {
+	}
+	return ex, nil
+}
+
+// use records that the plan reads n keys from the named bucket. Like the
+// other Explanation methods it does nothing on a nil Explanation, so the
+// query code can call it unconditionally.
+func (ex *Explanation) use(name string, n int) {
+	if ex == nil {
+		return
+	}
+	ex.Buckets = append(ex.Buckets, name)
+	ex.EstimatedKeys += n
+}
+
+// useBucket is like use for a whole bucket, counting its keys only when
+// explaining since that walks the whole bucket.
+func (ex *Explanation) useBucket(name string, b *bolt.Bucket) {
+	if ex == nil {
+		return
+	}
+	ex.use(name, b.Stats().KeyN)
+}
+
+// orCursor merges the index cursors of one condition, counting seeks when
+// explaining.
+func (ex *Explanation) orCursor(cs []CursorLike) CursorLike {
+	if ex == nil {
+		return makeOrCursor(cs)
+	}
+	if len(cs) > 1 {
+		for i, c := range cs {
+			cs[i] = &seekCounter{c, &ex.OrSeeks}
+		}
+	}
+	return &seekCounter{makeOrCursor(cs), &ex.AndSeeks}
+}
+
+// seekCounter counts the seeks made on a cursor.
+type seekCounter struct {
+	CursorLike
+	seeks *int
+}
+
+func (sc *seekCounter) Seek(seek []byte) (key, value []byte) {
+	*sc.seeks++
+	return sc.CursorLike.Seek(seek)
+}
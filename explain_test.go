@@ -0,0 +1,81 @@
+package bolt
+
+import (
+	"context"
+	"os"
+	"testing"
+
+	"github.com/nbd-wtf/go-nostr"
+)
+
+func TestExplain(t *testing.T) {
+	f, _ := os.CreateTemp("", "")
+	f.Close()
+	defer os.Remove(f.Name())
+	s := &BoltBackend{DatabaseURL: f.Name()}
+	s.Init()
+	s.DB.MaxBatchSize = 0
+
+	ctx := context.Background()
+	authors := []string{randHex(32), randHex(32)}
+	var ids []string
+	for i := 0; i < 10; i++ {
+		e := nostr.Event{
+			ID:        randHex(32),
+			PubKey:    authors[i%2],
+			CreatedAt: nostr.Timestamp(100 + i),
+			Kind:      i % 3,
+			Sig:       randHex(64),
+		}
+		if err := s.SaveEvent(ctx, &e); err != nil {
+			t.Fatal(err)
+		}
+		ids = append(ids, e.ID)
+	}
+
+	ex, err := s.Explain(&nostr.Filter{Limit: 3})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ex.Plan != PlanTimestamps || ex.EstimatedKeys != 10 || ex.Emitted != 3 || ex.Decoded != 3 {
+		t.Errorf("unexpected explanation for timestamps plan %+v", ex)
+	}
+
+	ex, err = s.Explain(&nostr.Filter{IDs: ids[:2]})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ex.Plan != PlanIDs || ex.EstimatedKeys != 2 || ex.KeysScanned != 2 || ex.Emitted != 2 {
+		t.Errorf("unexpected explanation for ids plan %+v", ex)
+	}
+
+	// authors 0 has kinds 0, 2, 1, 0, 2 and author 1 has kinds 1, 0, 2, 1, 0
+	ex, err = s.Explain(&nostr.Filter{Authors: authors, Kinds: []int{1}, Limit: 10})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ex.Plan != PlanIndexes {
+		t.Errorf("got plan %s, want %s", ex.Plan, PlanIndexes)
+	}
+	if len(ex.Buckets) != 3 || ex.EstimatedKeys != 13 {
+		t.Errorf("got buckets %v with %d keys, want 3 buckets with 13 keys", ex.Buckets, ex.EstimatedKeys)
+	}
+	if ex.Emitted != 3 || ex.Decoded != 3 {
+		t.Errorf("decoded %d and emitted %d events, want 3", ex.Decoded, ex.Emitted)
+	}
+	if ex.AndSeeks == 0 || ex.OrSeeks == 0 || ex.KeysScanned == 0 {
+		t.Errorf("no seeks or keys recorded %+v", ex)
+	}
+
+	ex, err = s.Explain(&nostr.Filter{IDs: ids[:1], Kinds: []int{1}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ex.Plan != PlanUnsupported || ex.Emitted != 0 {
+		t.Errorf("unexpected explanation for ids with kinds %+v", ex)
+	}
+
+	if _, err := s.Explain(&nostr.Filter{Search: "nostr"}); err == nil {
+		t.Error("invalid filter was explained")
+	}
+}
@@ -0,0 +1,142 @@
+package bolt
+
+import (
+	"bytes"
+	"context"
+	"encoding/gob"
+	"sort"
+
+	"github.com/nbd-wtf/go-nostr"
+	bolt "go.etcd.io/bbolt"
+)
+
+// scanPageSize bounds the number of keys ScanEvents reads per transaction,
+// so that a slow consumer doesn't keep old pages from being reused.
+const scanPageSize = 1000
+
+// ScanReport summarizes a call to ScanEvents.
+type ScanReport struct {
+	Events int
+	// Skipped counts the matching index entries whose event can't be
+	// decoded.
+	Skipped int
+}
+
+// ScanEvents calls fn for every stored event matching filter, newest first,
+// using the same indexes as QueryEvents. Unlike QueryEvents it doesn't cap
+// the number of results: filter.Limit, when set, keeps the newest Limit
+// events, and a nil filter matches every event. Events are read in pages,
+// each in its own read transaction, and fn is only called between them.
+// Events that can't be decoded are skipped and counted. Iteration stops at
+// the first error returned by fn.
+func (b *BoltBackend) ScanEvents(ctx context.Context, filter *nostr.Filter, fn func(*nostr.Event) error) (*ScanReport, error) {
+	if filter == nil {
+		filter = &nostr.Filter{}
+	}
+	r := &ScanReport{}
+	idx := makeFilterIndexBytes(filter)
+	// send passes a page to fn, reporting whether the limit was reached.
+	send := func(page []*nostr.Event) (bool, error) {
+		for _, evt := range page {
+			if err := fn(evt); err != nil {
+				return false, err
+			}
+			r.Events++
+			if filter.Limit > 0 && r.Events >= filter.Limit {
+				return true, nil
+			}
+		}
+		return false, nil
+	}
+	decode := func(v []byte) *nostr.Event {
+		evt := &nostr.Event{}
+		if err := gob.NewDecoder(bytes.NewBuffer(v)).Decode(evt); err != nil {
+			r.Skipped++
+			return nil
+		}
+		return evt
+	}
+
+	if filter.IDs != nil {
+		// ids are few enough to be read at once
+		var page []*nostr.Event
+		err := b.view(func(tx *bolt.Tx) error {
+			events := tx.Bucket([]byte("events"))
+			seen := make(map[string]struct{}, len(idx.IDs))
+			for _, id := range idx.IDs {
+				if _, ok := seen[string(id)]; ok {
+					continue
+				}
+				seen[string(id)] = struct{}{}
+				if v := events.Get(id); v != nil {
+					if evt := decode(v); evt != nil && filter.Matches(evt) {
+						page = append(page, evt)
+					}
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			return r, err
+		}
+		sort.SliceStable(page, func(i, j int) bool { return page[i].CreatedAt > page[j].CreatedAt })
+		_, err = send(page)
+		return r, err
+	}
+
+	// start is the key the next page starts before: until, then the last
+	// key read.
+	start := idx.Until
+	for {
+		if err := ctx.Err(); err != nil {
+			return r, err
+		}
+		var page []*nostr.Event
+		var exhausted bool
+		err := b.view(func(tx *bolt.Tx) error {
+			var c CursorLike
+			if filter.Kinds == nil && filter.Authors == nil && len(filter.Tags) == 0 {
+				c = tx.Bucket([]byte("timestamp_ids")).Cursor()
+			} else if c = indexCursor(tx, filter, idx, nil, nil); c == nil {
+				exhausted = true
+				return nil
+			}
+			events := tx.Bucket([]byte("events"))
+			var k []byte
+			if start != nil {
+				k, _ = c.Seek(start)
+			}
+			if k == nil {
+				k, _ = c.Last()
+			}
+			for n := 0; n < scanPageSize; k, _ = c.Prev() {
+				if k == nil || bytes.Compare(k, idx.Since) < 0 {
+					exhausted = true
+					return nil
+				}
+				if start != nil && bytes.Compare(k, start) >= 0 {
+					continue
+				}
+				n++
+				start = append(start[:0:0], k...)
+				v := events.Get(k[8:])
+				if v == nil {
+					continue
+				}
+				if evt := decode(v); evt != nil && filter.Matches(evt) {
+					page = append(page, evt)
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			return r, err
+		}
+		if done, err := send(page); done || err != nil {
+			return r, err
+		}
+		if exhausted {
+			return r, nil
+		}
+	}
+}
@@ -0,0 +1,88 @@
+package bolt
+
+import (
+	"context"
+	"encoding/hex"
+	"os"
+	"testing"
+
+	"github.com/nbd-wtf/go-nostr"
+	bolt "go.etcd.io/bbolt"
+)
+
+func TestScanEvents(t *testing.T) {
+	f, _ := os.CreateTemp("", "")
+	f.Close()
+	defer os.Remove(f.Name())
+	s := &BoltBackend{DatabaseURL: f.Name()}
+	s.Init()
+	s.DB.MaxBatchSize = 0
+
+	// more events than fit in a page, two per second
+	ctx := context.Background()
+	n := 2*scanPageSize + 500
+	saved := make([]nostr.Event, n)
+	for i := range saved {
+		saved[i] = nostr.Event{
+			ID:        randHex(32),
+			PubKey:    randHex(32),
+			CreatedAt: nostr.Timestamp(int64(i / 2)),
+			Kind:      i % 3,
+			Sig:       randHex(64),
+		}
+		if err := s.SaveEvent(ctx, &saved[i]); err != nil {
+			t.Fatal(err)
+		}
+	}
+	scan := func(filter *nostr.Filter) ([]*nostr.Event, *ScanReport) {
+		var events []*nostr.Event
+		r, err := s.ScanEvents(ctx, filter, func(evt *nostr.Event) error {
+			events = append(events, evt)
+			return nil
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		seen := make(map[string]bool, len(events))
+		for i, e := range events {
+			if seen[e.ID] {
+				t.Fatalf("%v returned %s twice", filter, e.ID)
+			}
+			seen[e.ID] = true
+			if i > 0 && e.CreatedAt > events[i-1].CreatedAt {
+				t.Fatalf("%v didn't return events newest first", filter)
+			}
+		}
+		return events, r
+	}
+
+	if events, r := scan(nil); len(events) != n || r.Events != n || r.Skipped != 0 {
+		t.Errorf("scanned %d events, report %+v, want %d", len(events), r, n)
+	}
+	if events, _ := scan(&nostr.Filter{Limit: 10}); len(events) != 10 || events[9].CreatedAt != saved[n-10].CreatedAt {
+		t.Errorf("limit didn't keep the newest events: got %d", len(events))
+	}
+	since, until := nostr.Timestamp(100), nostr.Timestamp(1100)
+	events, _ := scan(&nostr.Filter{Kinds: []int{1}, Since: &since, Until: &until})
+	var want int
+	for _, e := range saved {
+		if e.Kind == 1 && e.CreatedAt >= since && e.CreatedAt <= until {
+			want++
+		}
+	}
+	if len(events) != want {
+		t.Errorf("got %d kind 1 events between %d and %d, want %d", len(events), since, until, want)
+	}
+	if events, _ := scan(&nostr.Filter{IDs: []string{saved[0].ID, saved[7].ID, saved[0].ID}}); len(events) != 2 || events[0].ID != saved[7].ID {
+		t.Errorf("unexpected events for ids: %v", events)
+	}
+
+	// an event that can't be decoded is skipped
+	idb, _ := hex.DecodeString(saved[5].ID)
+	s.DB.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket([]byte("events")).Put(idb, []byte("garbage"))
+	})
+	if events, r := scan(nil); len(events) != n-1 || r.Skipped != 1 {
+		t.Errorf("scanned %d events, report %+v, want %d and one skipped", len(events), r, n-1)
+	}
+}
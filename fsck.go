@@ -0,0 +1,351 @@
+package bolt
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/gob"
+	"encoding/hex"
+	"fmt"
+
+	"github.com/nbd-wtf/go-nostr"
+	bolt "go.etcd.io/bbolt"
+)
+
+// maxReportedProblems bounds the number of problems kept in a VerifyReport.
+const maxReportedProblems = 1000
+
+// Problem is a single inconsistency found by Verify.
+type Problem struct {
+	Bucket string
+	Key    string
+	Reason string
+}
+
+func (p Problem) String() string {
+	return fmt.Sprintf("%s %s: %s", p.Bucket, p.Key, p.Reason)
+}
+
+// VerifyReport lists the inconsistencies found by Verify. Problems holds at
+// most maxReportedProblems entries, ProblemCount counts all of them.
+type VerifyReport struct {
+	Events       int
+	ProblemCount int
+	Problems     []Problem
+}
+
+// OK reports whether no problems were found.
+func (r *VerifyReport) OK() bool {
+	return r.ProblemCount == 0
+}
+
+func (r *VerifyReport) add(bucket string, key []byte, format string, args ...any) {
+	r.ProblemCount++
+	if len(r.Problems) < maxReportedProblems {
+		r.Problems = append(r.Problems, Problem{
+			Bucket: bucket,
+			Key:    hex.EncodeToString(key),
+			Reason: fmt.Sprintf(format, args...),
+		})
+	}
+}
+
+// isTagBucket reports whether a top level bucket holds a tag index. Tag
+// buckets are named after single letter tags, every other bucket has a
+// longer name.
+func isTagBucket(name []byte) bool {
+	return len(name) == 1
+}
+
+// Verify cross-checks every index against the events bucket, in both
+// directions, along with the per-author usage counters.
+func (b *BoltBackend) Verify(ctx context.Context) (*VerifyReport, error) {
+	r := &VerifyReport{}
+	err := b.view(func(tx *bolt.Tx) error {
+		if _, ok := rebuildProgress(tx); ok {
+			r.add("meta", rebuildKey, "index rebuild was interrupted, reopen the database for writing to finish it")
+		}
+		events := tx.Bucket([]byte("events"))
+		timestamps := tx.Bucket([]byte("timestamps"))
+		timestampIDs := tx.Bucket([]byte("timestamp_ids"))
+		authors := tx.Bucket([]byte("authors"))
+		kinds := tx.Bucket([]byte("kinds"))
+		usage := make(map[string]Usage)
+
+		// every event is in every index it should be
+		err := events.ForEach(func(k, v []byte) error {
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+			r.Events++
+			evt := nostr.Event{}
+			if err := gob.NewDecoder(bytes.NewBuffer(v)).Decode(&evt); err != nil {
+				r.add("events", k, "undecodable event: %v", err)
+				return nil
+			}
+			idx := makeEventIndexBytes(&evt)
+			if !bytes.Equal(idx.ID, k) {
+				r.add("events", k, "stored under the wrong id %s", evt.ID)
+				return nil
+			}
+			u := usage[string(idx.PubKey)]
+			u.Events++
+			u.Bytes += uint64(len(v))
+			usage[string(idx.PubKey)] = u
+
+			if ts := timestamps.Get(k); ts == nil {
+				r.add("timestamps", k, "missing entry")
+			} else if !bytes.Equal(ts, idx.Timestamp) {
+				r.add("timestamps", k, "wrong timestamp %x", ts)
+			}
+			if timestampIDs.Get(idx.TimestampID) == nil {
+				r.add("timestamp_ids", idx.TimestampID, "missing entry")
+			}
+			if ab := authors.Bucket(idx.PubKey); ab == nil || ab.Get(idx.TimestampID) == nil {
+				r.add("authors/"+evt.PubKey, idx.TimestampID, "missing entry")
+			}
+			if kb := kinds.Bucket(idx.Kind); kb == nil || kb.Get(idx.TimestampID) == nil {
+				r.add(fmt.Sprintf("kinds/%d", evt.Kind), idx.TimestampID, "missing entry")
+			}
+			for tagKey, tagValues := range idx.Tags {
+				tb := tx.Bucket([]byte(tagKey))
+				for _, tagValue := range tagValues {
+					if tb == nil || tb.Bucket(tagValue) == nil || tb.Bucket(tagValue).Get(idx.TimestampID) == nil {
+						r.add(tagKey+"/"+string(tagValue), idx.TimestampID, "missing entry")
+					}
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+
+		// every index entry points at an existing, matching event
+		lookup := func(bucket string, tsid []byte) *nostr.Event {
+			if len(tsid) != 8+32 {
+				r.add(bucket, tsid, "malformed key")
+				return nil
+			}
+			v := events.Get(tsid[8:])
+			if v == nil {
+				r.add(bucket, tsid, "no such event")
+				return nil
+			}
+			evt := &nostr.Event{}
+			if gob.NewDecoder(bytes.NewBuffer(v)).Decode(evt) != nil {
+				return nil
+			}
+			if binary.BigEndian.Uint64(tsid[:8]) != uint64(evt.CreatedAt) {
+				r.add(bucket, tsid, "event was created at %d", evt.CreatedAt)
+			}
+			return evt
+		}
+		err = timestamps.ForEach(func(k, v []byte) error {
+			if events.Get(k) == nil {
+				r.add("timestamps", k, "no such event")
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		err = timestampIDs.ForEach(func(k, v []byte) error {
+			lookup("timestamp_ids", k)
+			return ctx.Err()
+		})
+		if err != nil {
+			return err
+		}
+		err = authors.ForEach(func(pk, _ []byte) error {
+			bucket := "authors/" + hex.EncodeToString(pk)
+			return authors.Bucket(pk).ForEach(func(k, _ []byte) error {
+				if evt := lookup(bucket, k); evt != nil && evt.PubKey != hex.EncodeToString(pk) {
+					r.add(bucket, k, "event is by %s", evt.PubKey)
+				}
+				return ctx.Err()
+			})
+		})
+		if err != nil {
+			return err
+		}
+		err = kinds.ForEach(func(kind, _ []byte) error {
+			bucket := fmt.Sprintf("kinds/%d", binary.BigEndian.Uint64(kind))
+			return kinds.Bucket(kind).ForEach(func(k, _ []byte) error {
+				if evt := lookup(bucket, k); evt != nil && uint64(evt.Kind) != binary.BigEndian.Uint64(kind) {
+					r.add(bucket, k, "event has kind %d", evt.Kind)
+				}
+				return ctx.Err()
+			})
+		})
+		if err != nil {
+			return err
+		}
+		err = tx.ForEach(func(name []byte, tb *bolt.Bucket) error {
+			if !isTagBucket(name) {
+				return nil
+			}
+			return tb.ForEach(func(value, _ []byte) error {
+				bucket := string(name) + "/" + string(value)
+				vb := tb.Bucket(value)
+				if vb == nil {
+					r.add(string(name), value, "not a bucket")
+					return nil
+				}
+				return vb.ForEach(func(k, _ []byte) error {
+					evt := lookup(bucket, k)
+					if evt != nil && !evt.Tags.ContainsAny(string(name), []string{string(value)}) {
+						r.add(bucket, k, "event has no such tag")
+					}
+					return ctx.Err()
+				})
+			})
+		})
+		if err != nil {
+			return err
+		}
+
+		quotas := tx.Bucket([]byte("quotas"))
+		err = quotas.ForEach(func(pk, v []byte) error {
+			if u := decodeUsage(v); u != usage[string(pk)] {
+				r.add("quotas", pk, "usage is %d events, %d bytes but should be %d events, %d bytes",
+					u.Events, u.Bytes, usage[string(pk)].Events, usage[string(pk)].Bytes)
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		for pk := range usage {
+			if quotas.Get([]byte(pk)) == nil {
+				r.add("quotas", []byte(pk), "missing entry")
+			}
+		}
+		return nil
+	})
+	return r, err
+}
+
+// rebuildBatchSize bounds the number of events re-indexed per transaction.
+const rebuildBatchSize = 10000
+
+// rebuildKey marks, in the meta bucket, a database whose indexes are being
+// rebuilt. Its value is the last event re-indexed, empty until the first
+// batch is done.
+var rebuildKey = []byte("rebuilding")
+
+// rebuildProgress reports whether a rebuild is under way and returns the
+// last event it re-indexed.
+func rebuildProgress(tx *bolt.Tx) (after []byte, ok bool) {
+	meta := tx.Bucket([]byte("meta"))
+	if meta == nil {
+		return nil, false
+	}
+	k, v := meta.Cursor().Seek(rebuildKey)
+	if !bytes.Equal(k, rebuildKey) {
+		return nil, false
+	}
+	return append([]byte(nil), v...), true
+}
+
+// Rebuild drops every index and regenerates them, along with the usage
+// counters, from the events bucket. Writes are blocked while it runs but
+// queries are not, and may return incomplete results until it finishes.
+// Progress is recorded in the database: if Rebuild is interrupted, Verify
+// reports it and the next Init finishes the rebuild.
+func (b *BoltBackend) Rebuild(ctx context.Context) error {
+	if b.Options.ReadOnly {
+		return ErrReadOnly
+	}
+	done, err := b.begin()
+	if err != nil {
+		return err
+	}
+	defer done()
+	b.writeMu.Lock()
+	defer b.writeMu.Unlock()
+
+	b.dbMu.RLock()
+	defer b.dbMu.RUnlock()
+
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	err = b.DB.Update(func(tx *bolt.Tx) error {
+		var tags [][]byte
+		tx.ForEach(func(name []byte, _ *bolt.Bucket) error {
+			if isTagBucket(name) {
+				tags = append(tags, append([]byte(nil), name...))
+			}
+			return nil
+		})
+		for _, name := range tags {
+			if err := tx.DeleteBucket(name); err != nil {
+				return err
+			}
+		}
+		for _, name := range []string{"authors", "kinds", "timestamps", "timestamp_ids", "quotas"} {
+			if err := tx.DeleteBucket([]byte(name)); err != nil && err != bolt.ErrBucketNotFound {
+				return err
+			}
+			if _, err := tx.CreateBucket([]byte(name)); err != nil {
+				return err
+			}
+		}
+		return tx.Bucket([]byte("meta")).Put(rebuildKey, []byte{})
+	})
+	if err != nil {
+		return err
+	}
+	return b.reindex(ctx, nil)
+}
+
+// reindex adds the events stored after the key after to the indexes emptied
+// by Rebuild, recording its progress under rebuildKey and removing it once
+// every event has been re-indexed. The caller must keep other writes out.
+func (b *BoltBackend) reindex(ctx context.Context, after []byte) error {
+	for {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		var n int
+		err := b.DB.Update(func(tx *bolt.Tx) error {
+			c := tx.Bucket([]byte("events")).Cursor()
+			k, v := c.First()
+			if len(after) > 0 {
+				if k, v = c.Seek(after); bytes.Equal(k, after) {
+					k, v = c.Next()
+				}
+			}
+			for ; k != nil && n < rebuildBatchSize; k, v = c.Next() {
+				after = append(after[:0], k...)
+				n++
+				evt := nostr.Event{}
+				if err := gob.NewDecoder(bytes.NewBuffer(v)).Decode(&evt); err != nil {
+					continue
+				}
+				idx := makeEventIndexBytes(&evt)
+				if !bytes.Equal(idx.ID, k) {
+					continue
+				}
+				if _, _, err := addUsage(tx, idx.PubKey, 1, int64(len(v))); err != nil {
+					return err
+				}
+				if err := putEventIndexes(tx, idx); err != nil {
+					return err
+				}
+			}
+			meta := tx.Bucket([]byte("meta"))
+			if n < rebuildBatchSize {
+				return meta.Delete(rebuildKey)
+			}
+			return meta.Put(rebuildKey, after)
+		})
+		if err != nil {
+			return err
+		}
+		if n < rebuildBatchSize {
+			return nil
+		}
+	}
+}
@@ -0,0 +1,84 @@
+package bolt
+
+import (
+	"context"
+	"encoding/hex"
+	"os"
+	"testing"
+
+	"github.com/fiatjaf/relayer/v2"
+	bolt "go.etcd.io/bbolt"
+)
+
+func TestVerifyRebuild(t *testing.T) {
+	f, _ := os.CreateTemp("", "")
+	f.Close()
+	defer os.Remove(f.Name())
+	s := &BoltBackend{DatabaseURL: f.Name()}
+	s.Init()
+	s.DB.MaxBatchSize = 0
+
+	ctx := context.Background()
+	ids, _, _ := setupStorage([]relayer.Storage{s}, 1000)
+
+	r, err := s.Verify(ctx)
+	if err != nil || !r.OK() || r.Events != 1000 {
+		t.Fatal("unexpected problems", r, err)
+	}
+
+	// orphan some index entries and drop others
+	s.DB.Update(func(tx *bolt.Tx) error {
+		idb, _ := hex.DecodeString(ids[0])
+		tx.Bucket([]byte("events")).Delete(idb)
+		idb, _ = hex.DecodeString(ids[1])
+		tsid := make([]byte, 8+32)
+		tsid[7] = 1
+		copy(tsid[8:], idb)
+		tx.Bucket([]byte("timestamp_ids")).Delete(tsid)
+		return nil
+	})
+
+	r, err = s.Verify(ctx)
+	if err != nil || r.OK() {
+		t.Fatal("problems not found", r, err)
+	}
+
+	if err := s.Rebuild(ctx); err != nil {
+		t.Fatal(err)
+	}
+	r, err = s.Verify(ctx)
+	if err != nil || !r.OK() || r.Events != 999 {
+		t.Fatal("problems after rebuild", r.Problems, err)
+	}
+
+	// a rebuild interrupted after emptying the indexes is reported and
+	// finished by the next Init
+	s.DB.Update(func(tx *bolt.Tx) error {
+		for _, name := range []string{"authors", "kinds", "timestamps", "timestamp_ids", "quotas"} {
+			tx.DeleteBucket([]byte(name))
+			tx.CreateBucket([]byte(name))
+		}
+		return tx.Bucket([]byte("meta")).Put(rebuildKey, []byte{})
+	})
+	r, err = s.Verify(ctx)
+	if err != nil || r.OK() || r.Problems[0].Bucket != "meta" {
+		t.Fatal("interrupted rebuild not reported", r.Problems, err)
+	}
+	if err := s.Close(ctx); err != nil {
+		t.Fatal(err)
+	}
+	ro := &BoltBackend{DatabaseURL: f.Name(), Options: Options{ReadOnly: true}}
+	if err := ro.Init(); err == nil {
+		ro.Close(ctx)
+		t.Error("opened a database with an interrupted rebuild read-only")
+	}
+	s = &BoltBackend{DatabaseURL: f.Name()}
+	if err := s.Init(); err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close(ctx)
+	r, err = s.Verify(ctx)
+	if err != nil || !r.OK() || r.Events != 999 {
+		t.Fatal("problems after finishing the rebuild", r.Problems, err)
+	}
+}
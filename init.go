@@ -1,21 +1,45 @@
 package bolt
 
 import (
-	"time"
+	"context"
+	"fmt"
+	"sync"
 
 	bolt "go.etcd.io/bbolt"
 )
 
-func (b *BoltBackend) Init() error {
-	db, err := bolt.Open(b.DatabaseURL, 0600, &bolt.Options{Timeout: 1 * time.Second})
+func (b *BoltBackend) Init() (err error) {
+	db, err := b.openDB(b.DatabaseURL, b.Options.ReadOnly)
 	if err != nil {
 		return err
 	}
+	// release the file lock if the database can't be used
+	defer func() {
+		if err != nil {
+			db.Close()
+		}
+	}()
 	b.DB = db
-	//b.DB.FreelistType = "hashmap"
-	//b.DB.MaxBatchDelay = time.Second
+	b.dbReaders = &sync.WaitGroup{}
+	if b.Options.ReadOnly {
+		if err := b.DB.View(checkReadableSchema); err != nil {
+			return err
+		}
+		b.startRefresh()
+		return nil
+	}
 
 	err = b.DB.Update(func(tx *bolt.Tx) error {
+		version, ok := readSchemaVersion(tx)
+		if !ok {
+			version = schemaVersion
+		}
+		if version > schemaVersion {
+			return fmt.Errorf("database schema version %d is newer than supported version %d", version, schemaVersion)
+		}
+		if err := writeSchemaVersion(tx, version); err != nil {
+			return err
+		}
 		if _, err := tx.CreateBucketIfNotExists([]byte("events")); err != nil {
 			return err
 		}
@@ -31,8 +55,37 @@ func (b *BoltBackend) Init() error {
 		if _, err := tx.CreateBucketIfNotExists([]byte("timestamp_ids")); err != nil {
 			return err
 		}
+		if _, err := tx.CreateBucketIfNotExists([]byte("quotas")); err != nil {
+			return err
+		}
+		if _, err := tx.CreateBucketIfNotExists([]byte("tombstones")); err != nil {
+			return err
+		}
+		if _, err := tx.CreateBucketIfNotExists([]byte("blocklist")); err != nil {
+			return err
+		}
+		if _, err := tx.CreateBucketIfNotExists([]byte("allowlist")); err != nil {
+			return err
+		}
 		return nil
 	})
+	if err != nil {
+		return err
+	}
 
-	return err
+	if err := b.migrateSchema(); err != nil {
+		return err
+	}
+
+	var after []byte
+	var rebuilding bool
+	b.DB.View(func(tx *bolt.Tx) error {
+		after, rebuilding = rebuildProgress(tx)
+		return nil
+	})
+	if rebuilding {
+		b.logf("finishing the interrupted index rebuild of %s", b.DatabaseURL)
+		return b.reindex(context.Background(), after)
+	}
+	return nil
 }
@@ -0,0 +1,241 @@
+package bolt
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"sync/atomic"
+	"time"
+)
+
+// metricsPrefix is prepended to the name of every exported metric.
+const metricsPrefix = "boltrelayer_"
+
+// durationBuckets are the upper bounds, in seconds, of the duration
+// histograms.
+var durationBuckets = [...]float64{.001, .005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+// histogram counts durations in durationBuckets. counts are per bucket and
+// made cumulative when written, the last one holding durations above every
+// bound.
+type histogram struct {
+	counts [len(durationBuckets) + 1]atomic.Uint64
+	sum    atomic.Int64
+}
+
+func (h *histogram) observe(d time.Duration) {
+	i := 0
+	for i < len(durationBuckets) && d.Seconds() > durationBuckets[i] {
+		i++
+	}
+	h.counts[i].Add(1)
+	h.sum.Add(int64(d))
+}
+
+// since observes the time elapsed since start. It is meant to be deferred.
+func (h *histogram) since(start time.Time) {
+	h.observe(time.Since(start))
+}
+
+type txType int
+
+const (
+	txView txType = iota
+	txUpdate
+	txBatch
+)
+
+var txTypes = [...]string{txView: "view", txUpdate: "update", txBatch: "batch"}
+
+// metrics holds the counters exposed by WriteMetrics. They live for as long
+// as the backend and are safe for concurrent use.
+type metrics struct {
+	saved, duplicates, rejected atomic.Uint64
+	deleted, deleteRejected     atomic.Uint64
+	queries                     [len(queryPlans)]atomic.Uint64
+	truncated                   atomic.Uint64
+	keysScanned, emitted        atomic.Uint64
+	queryDuration               histogram
+	tx                          [len(txTypes)]histogram
+}
+
+func (m *metrics) countSave(err error) {
+	switch err {
+	case nil:
+		m.saved.Add(1)
+	case ErrDupEvent:
+		m.duplicates.Add(1)
+	default:
+		m.rejected.Add(1)
+	}
+}
+
+func (m *metrics) countDelete(err error) {
+	if err != nil {
+		m.deleteRejected.Add(1)
+		return
+	}
+	m.deleted.Add(1)
+}
+
+func (m *metrics) countQuery(stats *QueryStats) {
+	for i, plan := range queryPlans {
+		if plan == stats.Plan {
+			m.queries[i].Add(1)
+		}
+	}
+	if stats.Truncated {
+		m.truncated.Add(1)
+	}
+	m.keysScanned.Add(uint64(stats.KeysScanned))
+	m.emitted.Add(uint64(stats.Emitted))
+	m.queryDuration.observe(stats.Duration)
+}
+
+// WriteMetrics writes the backend's metrics to w in the Prometheus text
+// exposition format. Besides its own counters it reports the values of
+// DB.Stats, which start over when the database is compacted.
+func (b *BoltBackend) WriteMetrics(w io.Writer) error {
+	m := &b.metrics
+	pw := &promWriter{w: bufio.NewWriter(w)}
+
+	pw.header("saves_total", "counter", "Events passed to SaveEvent by result.")
+	pw.sample("saves_total", `result="saved"`, float64(m.saved.Load()))
+	pw.sample("saves_total", `result="duplicate"`, float64(m.duplicates.Load()))
+	pw.sample("saves_total", `result="rejected"`, float64(m.rejected.Load()))
+
+	pw.header("deletes_total", "counter", "Calls to DeleteEvent by result.")
+	pw.sample("deletes_total", `result="deleted"`, float64(m.deleted.Load()))
+	pw.sample("deletes_total", `result="rejected"`, float64(m.deleteRejected.Load()))
+
+	pw.header("queries_total", "counter", "Queries run by plan.")
+	for i, plan := range queryPlans {
+		pw.sample("queries_total", fmt.Sprintf("plan=%q", plan), float64(m.queries[i].Load()))
+	}
+	pw.header("queries_truncated_total", "counter", "Queries cut short by MaxQueryKeys or MaxQueryDuration.")
+	pw.sample("queries_truncated_total", "", float64(m.truncated.Load()))
+	pw.header("query_keys_scanned_total", "counter", "Index keys scanned by queries.")
+	pw.sample("query_keys_scanned_total", "", float64(m.keysScanned.Load()))
+	pw.header("query_events_emitted_total", "counter", "Events returned by queries.")
+	pw.sample("query_events_emitted_total", "", float64(m.emitted.Load()))
+	pw.header("query_duration_seconds", "histogram", "Time taken by queries.")
+	pw.histogram("query_duration_seconds", "", &m.queryDuration)
+
+	pw.header("tx_duration_seconds", "histogram", "Time taken by transactions by type.")
+	for i, typ := range txTypes {
+		pw.histogram("tx_duration_seconds", fmt.Sprintf("type=%q", typ), &m.tx[i])
+	}
+
+	b.dbMu.RLock()
+	stats := b.DB.Stats()
+	b.dbMu.RUnlock()
+	gauges := []struct {
+		name, help string
+		value      int64
+	}{
+		{"db_free_pages", "Free pages on the freelist.", int64(stats.FreePageN)},
+		{"db_pending_pages", "Pages waiting for open transactions to be freed.", int64(stats.PendingPageN)},
+		{"db_free_alloc_bytes", "Bytes allocated in free pages.", int64(stats.FreeAlloc)},
+		{"db_freelist_inuse_bytes", "Bytes used by the freelist.", int64(stats.FreelistInuse)},
+		{"db_open_read_txs", "Read transactions currently open.", int64(stats.OpenTxN)},
+	}
+	for _, g := range gauges {
+		pw.header(g.name, "gauge", g.help)
+		pw.sample(g.name, "", float64(g.value))
+	}
+	counters := []struct {
+		name, help string
+		value      int64
+	}{
+		{"db_read_txs_total", "Read transactions started.", int64(stats.TxN)},
+		{"db_tx_pages_allocated_total", "Pages allocated by transactions.", stats.TxStats.GetPageCount()},
+		{"db_tx_page_alloc_bytes_total", "Bytes allocated for pages by transactions.", stats.TxStats.GetPageAlloc()},
+		{"db_tx_cursors_total", "Cursors created by transactions.", stats.TxStats.GetCursorCount()},
+		{"db_tx_nodes_total", "Nodes allocated by transactions.", stats.TxStats.GetNodeCount()},
+		{"db_tx_rebalances_total", "Node rebalances.", stats.TxStats.GetRebalance()},
+		{"db_tx_splits_total", "Node splits.", stats.TxStats.GetSplit()},
+		{"db_tx_spills_total", "Nodes spilled to disk.", stats.TxStats.GetSpill()},
+		{"db_tx_writes_total", "Writes to disk.", stats.TxStats.GetWrite()},
+	}
+	for _, c := range counters {
+		pw.header(c.name, "counter", c.help)
+		pw.sample(c.name, "", float64(c.value))
+	}
+	pw.header("db_tx_rebalance_seconds_total", "counter", "Time spent rebalancing nodes.")
+	pw.sample("db_tx_rebalance_seconds_total", "", stats.TxStats.GetRebalanceTime().Seconds())
+	pw.header("db_tx_spill_seconds_total", "counter", "Time spent spilling nodes.")
+	pw.sample("db_tx_spill_seconds_total", "", stats.TxStats.GetSpillTime().Seconds())
+	pw.header("db_tx_write_seconds_total", "counter", "Time spent writing to disk.")
+	pw.sample("db_tx_write_seconds_total", "", stats.TxStats.GetWriteTime().Seconds())
+
+	if size, err := b.fileSize(); err == nil {
+		pw.header("db_size_bytes", "gauge", "Size of the database file.")
+		pw.sample("db_size_bytes", "", float64(size))
+	}
+
+	if pw.err != nil {
+		return pw.err
+	}
+	return pw.w.Flush()
+}
+
+// MetricsHandler returns an http.Handler that serves WriteMetrics, for
+// scraping by Prometheus.
+func (b *BoltBackend) MetricsHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			w.Header().Set("Allow", "GET, HEAD")
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		if r.Method == http.MethodHead {
+			return
+		}
+		b.WriteMetrics(w)
+	})
+}
+
+// promWriter writes metrics in the Prometheus text format, keeping the first
+// error.
+type promWriter struct {
+	w   *bufio.Writer
+	err error
+}
+
+func (pw *promWriter) printf(format string, v ...any) {
+	if pw.err == nil {
+		_, pw.err = fmt.Fprintf(pw.w, format, v...)
+	}
+}
+
+func (pw *promWriter) header(name, typ, help string) {
+	pw.printf("# HELP %s%s %s\n# TYPE %s%s %s\n", metricsPrefix, name, help, metricsPrefix, name, typ)
+}
+
+func (pw *promWriter) sample(name, labels string, value float64) {
+	if labels != "" {
+		labels = "{" + labels + "}"
+	}
+	pw.printf("%s%s%s %s\n", metricsPrefix, name, labels, strconv.FormatFloat(value, 'g', -1, 64))
+}
+
+func (pw *promWriter) histogram(name, labels string, h *histogram) {
+	sep := ""
+	if labels != "" {
+		sep = ","
+	}
+	var count uint64
+	for i := range h.counts {
+		count += h.counts[i].Load()
+		le := "+Inf"
+		if i < len(durationBuckets) {
+			le = strconv.FormatFloat(durationBuckets[i], 'g', -1, 64)
+		}
+		pw.sample(name+"_bucket", fmt.Sprintf("%s%sle=%q", labels, sep, le), float64(count))
+	}
+	pw.sample(name+"_sum", labels, time.Duration(h.sum.Load()).Seconds())
+	pw.sample(name+"_count", labels, float64(count))
+}
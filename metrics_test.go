@@ -0,0 +1,80 @@
+package bolt
+
+import (
+	"context"
+	"io"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/nbd-wtf/go-nostr"
+)
+
+func TestMetrics(t *testing.T) {
+	f, _ := os.CreateTemp("", "")
+	f.Close()
+	defer os.Remove(f.Name())
+	s := &BoltBackend{DatabaseURL: f.Name()}
+	s.Init()
+	s.DB.MaxBatchSize = 0
+
+	ctx := context.Background()
+	pubkey := randHex(32)
+	events := make([]nostr.Event, 2)
+	for i := range events {
+		events[i] = nostr.Event{
+			ID:        randHex(32),
+			PubKey:    pubkey,
+			CreatedAt: nostr.Timestamp(1000 + i),
+			Kind:      1,
+			Sig:       randHex(64),
+		}
+		if err := s.SaveEvent(ctx, &events[i]); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := s.SaveEvent(ctx, &events[0]); err != ErrDupEvent {
+		t.Fatalf("expected duplicate, got %v", err)
+	}
+	if err := s.DeleteEvent(ctx, events[1].ID, pubkey); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.DeleteEvent(ctx, events[1].ID, pubkey); err == nil {
+		t.Fatal("deleted event twice")
+	}
+	ch, _ := s.QueryEvents(ctx, &nostr.Filter{Authors: []string{pubkey}})
+	for range ch {
+	}
+	ch, _ = s.QueryEvents(ctx, &nostr.Filter{IDs: []string{events[0].ID}})
+	for range ch {
+	}
+
+	rec := httptest.NewRecorder()
+	s.MetricsHandler().ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+	if ct := rec.Header().Get("Content-Type"); !strings.HasPrefix(ct, "text/plain") {
+		t.Errorf("unexpected content type %q", ct)
+	}
+	body, _ := io.ReadAll(rec.Body)
+	for _, line := range []string{
+		`# TYPE boltrelayer_saves_total counter`,
+		`boltrelayer_saves_total{result="saved"} 2`,
+		`boltrelayer_saves_total{result="duplicate"} 1`,
+		`boltrelayer_deletes_total{result="deleted"} 1`,
+		`boltrelayer_deletes_total{result="rejected"} 1`,
+		`boltrelayer_queries_total{plan="indexes"} 1`,
+		`boltrelayer_queries_total{plan="ids"} 1`,
+		`boltrelayer_query_events_emitted_total 2`,
+		`boltrelayer_query_duration_seconds_bucket{le="+Inf"} 2`,
+		`boltrelayer_query_duration_seconds_count 2`,
+		`# TYPE boltrelayer_tx_duration_seconds histogram`,
+		`# TYPE boltrelayer_db_free_pages gauge`,
+	} {
+		if !strings.Contains(string(body), line+"\n") {
+			t.Errorf("metrics are missing %q", line)
+		}
+	}
+	if t.Failed() {
+		t.Log(string(body))
+	}
+}
@@ -0,0 +1,151 @@
+package bolt
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"sync"
+
+	"github.com/fiatjaf/relayer/v2"
+	"github.com/nbd-wtf/go-nostr"
+	bolt "go.etcd.io/bbolt"
+)
+
+// migratePageSize is the limit used for each QueryEvents call on the source.
+// Most backends, including this one, cap limits at 100.
+const migratePageSize = 100
+
+// MigrateReport summarizes a call to MigrateFrom.
+type MigrateReport struct {
+	Pages      int
+	Saved      int
+	Duplicates int
+	Rejected   int
+	// Incomplete counts the seconds holding more events than src returns
+	// for a single query, some of which may not have been copied.
+	Incomplete int
+}
+
+// MigrateFrom copies every event from src into b, newest first, by paging
+// through src.QueryEvents with a decreasing until. After each page the next
+// until is stored in b under name, so an interrupted migration resumes where
+// it stopped when called again with the same name. The checkpoint is removed
+// once the migration completes.
+//
+// When a whole page was created in the same second, that second is queried
+// again with a larger limit until every event created in it fits. Sources
+// that cap their limits, like this one, can't return more, so the second is
+// counted in the report's Incomplete and skipped.
+func (b *BoltBackend) MigrateFrom(ctx context.Context, src relayer.Storage, name string) (*MigrateReport, error) {
+	key := []byte("migrate/" + name)
+	until, err := b.migrateCheckpoint(key)
+	if err != nil {
+		return nil, err
+	}
+	r := &MigrateReport{}
+	// limit grows while the pages are all created at until, and prev is
+	// the size of the last such page.
+	limit, prev := migratePageSize, 0
+pages:
+	for {
+		if err := ctx.Err(); err != nil {
+			return r, err
+		}
+		ts := nostr.Timestamp(until)
+		ch, err := src.QueryEvents(ctx, &nostr.Filter{Until: &ts, Limit: limit})
+		if err != nil {
+			return r, err
+		}
+		var page []*nostr.Event
+		for evt := range ch {
+			page = append(page, evt)
+		}
+		r.Pages++
+		oldest := until
+		for _, evt := range page {
+			if uint64(evt.CreatedAt) < oldest {
+				oldest = uint64(evt.CreatedAt)
+			}
+		}
+		b.migratePage(ctx, page, r)
+		// a canceled query closes its channel early, so the page being
+		// short doesn't mean the migration is complete
+		if err := ctx.Err(); err != nil {
+			return r, err
+		}
+		next := oldest
+		switch {
+		case len(page) > 0 && oldest == until && len(page) >= limit:
+			// the second may hold more events than the page, ask for
+			// more of them; those already copied come back as duplicates
+			limit, prev = 2*limit, len(page)
+			continue
+		case len(page) > 0 && oldest == until && prev > 0 && len(page) <= prev:
+			// src capped the larger limit, so the rest of the second
+			// can't be read
+			b.logf("migrate %s: more than %d events at %d, some may not have been copied", name, prev, until)
+			r.Incomplete++
+			if until == 0 {
+				break pages
+			}
+			next = until - 1
+		case len(page) < limit || oldest == 0:
+			break pages
+		}
+		// The next page starts at the oldest timestamp seen, since more
+		// events may share it.
+		until, limit, prev = next, migratePageSize, 0
+		if err := b.update(func(tx *bolt.Tx) error {
+			v := make([]byte, 8)
+			binary.BigEndian.PutUint64(v, until)
+			return tx.Bucket([]byte("meta")).Put(key, v)
+		}); err != nil {
+			return r, err
+		}
+	}
+	return r, b.update(func(tx *bolt.Tx) error {
+		return tx.Bucket([]byte("meta")).Delete(key)
+	})
+}
+
+func (b *BoltBackend) migrateCheckpoint(key []byte) (until uint64, err error) {
+	until = math.MaxInt64
+	err = b.view(func(tx *bolt.Tx) error {
+		if v := tx.Bucket([]byte("meta")).Get(key); v != nil {
+			if len(v) != 8 {
+				return fmt.Errorf("invalid migration checkpoint %q", key)
+			}
+			until = binary.BigEndian.Uint64(v)
+		}
+		return nil
+	})
+	return
+}
+
+// migratePage saves the events of a page concurrently so that SaveEvent's
+// batches can group them into few transactions.
+func (b *BoltBackend) migratePage(ctx context.Context, page []*nostr.Event, r *MigrateReport) {
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	for _, evt := range page {
+		wg.Add(1)
+		go func(evt *nostr.Event) {
+			defer wg.Done()
+			err := b.SaveEvent(ctx, evt)
+			mu.Lock()
+			defer mu.Unlock()
+			switch {
+			case err == nil:
+				r.Saved++
+			case errors.Is(err, ErrDupEvent):
+				r.Duplicates++
+			default:
+				r.Rejected++
+				b.logf("migrate: rejected %s: %v", evt.ID, err)
+			}
+		}(evt)
+	}
+	wg.Wait()
+}
@@ -0,0 +1,203 @@
+package bolt
+
+import (
+	"context"
+	"errors"
+	"math"
+	"os"
+	"sort"
+	"testing"
+
+	"github.com/fiatjaf/relayer/v2"
+	"github.com/fiatjaf/relayer/v2/storage/sqlite3"
+	"github.com/nbd-wtf/go-nostr"
+)
+
+// failingStorage stops answering queries after a number of pages.
+type failingStorage struct {
+	relayer.Storage
+	pages int
+}
+
+func (s *failingStorage) QueryEvents(ctx context.Context, filter *nostr.Filter) (chan *nostr.Event, error) {
+	if s.pages == 0 {
+		return nil, errors.New("interrupted")
+	}
+	s.pages--
+	return s.Storage.QueryEvents(ctx, filter)
+}
+
+// cancelingStorage cancels the migration halfway through a page, once a
+// number of pages have been answered.
+type cancelingStorage struct {
+	relayer.Storage
+	pages  int
+	cancel context.CancelFunc
+}
+
+func (s *cancelingStorage) QueryEvents(ctx context.Context, filter *nostr.Filter) (chan *nostr.Event, error) {
+	if s.pages > 0 {
+		s.pages--
+		return s.Storage.QueryEvents(ctx, filter)
+	}
+	src, err := s.Storage.QueryEvents(ctx, filter)
+	if err != nil {
+		return nil, err
+	}
+	ch := make(chan *nostr.Event)
+	go func() {
+		defer close(ch)
+		for i := 0; i < migratePageSize/2; i++ {
+			ch <- <-src
+		}
+		s.cancel()
+		for range src {
+		}
+	}()
+	return ch, nil
+}
+
+// sliceStorage serves events newest first and honours any limit.
+type sliceStorage struct {
+	relayer.Storage
+	events []nostr.Event
+}
+
+func (s *sliceStorage) QueryEvents(ctx context.Context, filter *nostr.Filter) (chan *nostr.Event, error) {
+	sort.Slice(s.events, func(i, j int) bool { return s.events[i].CreatedAt > s.events[j].CreatedAt })
+	ch := make(chan *nostr.Event, len(s.events))
+	defer close(ch)
+	for i := range s.events {
+		if len(ch) == filter.Limit {
+			break
+		}
+		if filter.Matches(&s.events[i]) {
+			ch <- &s.events[i]
+		}
+	}
+	return ch, nil
+}
+
+func TestMigrateCrowdedSecond(t *testing.T) {
+	ctx := context.Background()
+	var events []nostr.Event
+	add := func(n int, ts func(i int) int64) {
+		for i := 0; i < n; i++ {
+			events = append(events, nostr.Event{
+				ID:        randHex(32),
+				PubKey:    randHex(32),
+				CreatedAt: nostr.Timestamp(ts(i)),
+				Kind:      1,
+				Sig:       randHex(64),
+			})
+		}
+	}
+	add(20, func(i int) int64 { return int64(2000 + i) })
+	add(5*migratePageSize/2, func(int) int64 { return 1000 })
+	add(50, func(i int) int64 { return int64(10 + i) })
+
+	f1, _ := os.CreateTemp("", "")
+	f1.Close()
+	defer os.Remove(f1.Name())
+	sql := &sqlite3.SQLite3Backend{DatabaseURL: f1.Name()}
+	sql.Init()
+	for i := range events {
+		sql.SaveEvent(ctx, &events[i])
+	}
+
+	for _, tc := range []struct {
+		name       string
+		src        relayer.Storage
+		saved      int
+		incomplete int
+	}{
+		{"uncapped", &sliceStorage{events: events}, len(events), 0},
+		// sqlite caps limits at 100, so only 100 events of the crowded
+		// second can be read
+		{"capped", sql, len(events) - 3*migratePageSize/2, 1},
+	} {
+		f, _ := os.CreateTemp("", "")
+		f.Close()
+		defer os.Remove(f.Name())
+		s := &BoltBackend{DatabaseURL: f.Name()}
+		s.Init()
+		s.DB.MaxBatchSize = 0
+
+		r, err := s.MigrateFrom(ctx, tc.src, "src")
+		if err != nil {
+			t.Fatal(tc.name, err)
+		}
+		if r.Saved != tc.saved || r.Rejected != 0 || r.Incomplete != tc.incomplete {
+			t.Errorf("%s: unexpected migration results %+v", tc.name, r)
+		}
+		if until, _ := s.migrateCheckpoint([]byte("migrate/src")); until != math.MaxInt64 {
+			t.Errorf("%s: checkpoint left at %d", tc.name, until)
+		}
+		s.Close(ctx)
+	}
+}
+
+func TestMigrateFrom(t *testing.T) {
+	f1, _ := os.CreateTemp("", "")
+	f1.Close()
+	defer os.Remove(f1.Name())
+	sql := &sqlite3.SQLite3Backend{DatabaseURL: f1.Name()}
+	sql.Init()
+
+	f2, _ := os.CreateTemp("", "")
+	f2.Close()
+	defer os.Remove(f2.Name())
+	s := &BoltBackend{DatabaseURL: f2.Name()}
+	s.Init()
+
+	// kind 1 since SQLite3Backend only keeps the latest replaceable events
+	ctx := context.Background()
+	n := 1000
+	ids := make([]string, n)
+	for i := range ids {
+		e := nostr.Event{
+			ID:        randHex(32),
+			PubKey:    randHex(32),
+			CreatedAt: nostr.Timestamp(int64(i)),
+			Kind:      1,
+			Content:   "arbitrary string",
+			Sig:       randHex(64),
+		}
+		ids[i] = e.ID
+		sql.SaveEvent(ctx, &e)
+	}
+
+	r, err := s.MigrateFrom(ctx, &failingStorage{Storage: sql, pages: 4}, "sqlite")
+	if err == nil {
+		t.Fatal("expected interrupted migration")
+	}
+	first := r.Saved
+
+	canceled, cancel := context.WithCancel(ctx)
+	defer cancel()
+	r, err = s.MigrateFrom(canceled, &cancelingStorage{Storage: sql, pages: 2, cancel: cancel}, "sqlite")
+	if err != context.Canceled {
+		t.Fatalf("got %v from a canceled migration, want context.Canceled", err)
+	}
+	first += r.Saved
+
+	r, err = s.MigrateFrom(ctx, sql, "sqlite")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if first+r.Saved != n || r.Rejected != 0 {
+		t.Error("unexpected migration results", first, r)
+	}
+	if r.Duplicates > migratePageSize {
+		t.Error("migration did not resume from checkpoint", r)
+	}
+
+	ch, _ := s.QueryEvents(ctx, &nostr.Filter{IDs: ids[:50]})
+	var i int
+	for range ch {
+		i++
+	}
+	if i != 50 {
+		t.Error("unexpected number of events", i)
+	}
+}
@@ -0,0 +1,139 @@
+package bolt
+
+import (
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/nbd-wtf/go-nostr"
+)
+
+// Logger is the logging interface used by BoltBackend. *log.Logger
+// satisfies it, and other loggers such as slog need a one line adapter.
+type Logger interface {
+	Printf(format string, v ...any)
+}
+
+func (b *BoltBackend) logf(format string, v ...any) {
+	if b.Logger != nil {
+		b.Logger.Printf(format, v...)
+		return
+	}
+	log.Printf(format, v...)
+}
+
+// defaultSlowThreshold is used when SlowThreshold isn't set.
+const defaultSlowThreshold = time.Second
+
+func (b *BoltBackend) slowThreshold() time.Duration {
+	if b.SlowThreshold > 0 {
+		return b.SlowThreshold
+	}
+	return defaultSlowThreshold
+}
+
+// slowMonitor keeps track of in-flight operations and logs the ones that run
+// longer than the slow threshold, backing off exponentially for each one.
+// A single goroutine serves every operation; it is started by the first
+// tracked operation.
+type slowMonitor struct {
+	mu      sync.Mutex
+	ops     map[uint64]*inflightOp
+	next    uint64
+	quit    chan struct{}
+	stopped bool
+}
+
+type inflightOp struct {
+	describe func() string
+	start    time.Time
+	report   time.Time
+}
+
+// track registers an operation with the slow monitor. describe is only
+// called if the operation needs to be logged. The returned function must be
+// called when the operation completes.
+func (b *BoltBackend) track(describe func() string) (done func()) {
+	m := &b.monitor
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.stopped {
+		return func() {}
+	}
+	if m.ops == nil {
+		m.ops = make(map[uint64]*inflightOp)
+		m.quit = make(chan struct{})
+		go b.runMonitor(m.quit)
+	}
+	now := time.Now()
+	m.next++
+	id := m.next
+	m.ops[id] = &inflightOp{describe: describe, start: now, report: now.Add(b.slowThreshold())}
+	return func() {
+		m.mu.Lock()
+		delete(m.ops, id)
+		m.mu.Unlock()
+	}
+}
+
+func (b *BoltBackend) runMonitor(quit chan struct{}) {
+	m := &b.monitor
+	interval := b.slowThreshold() / 2
+	if interval < time.Millisecond {
+		interval = time.Millisecond
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-quit:
+			return
+		case now := <-ticker.C:
+			var slow []string
+			m.mu.Lock()
+			for _, op := range m.ops {
+				if now.Before(op.report) {
+					continue
+				}
+				running := now.Sub(op.start)
+				op.report = now.Add(running)
+				slow = append(slow, op.describe()+" has been running for "+running.Round(time.Millisecond).String())
+			}
+			m.mu.Unlock()
+			for _, s := range slow {
+				b.logf("%s", s)
+			}
+		}
+	}
+}
+
+// stopMonitor stops the slow monitor goroutine, if it was started. Later
+// operations are no longer tracked.
+func (b *BoltBackend) stopMonitor() {
+	m := &b.monitor
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.stopped = true
+	if m.quit != nil {
+		close(m.quit)
+		m.quit = nil
+	}
+}
+
+// maxDescribedFilter bounds the length of filters in log lines.
+const maxDescribedFilter = 200
+
+// describeEvent identifies an event in log lines without dumping its content.
+func describeEvent(evt *nostr.Event) string {
+	return fmt.Sprintf("event %s (kind %d)", evt.ID, evt.Kind)
+}
+
+// describeFilter formats a filter for log lines, shortening long ones.
+func describeFilter(filter *nostr.Filter) string {
+	s := filter.String()
+	if len(s) > maxDescribedFilter {
+		s = s[:maxDescribedFilter] + "..."
+	}
+	return s
+}
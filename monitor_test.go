@@ -0,0 +1,119 @@
+package bolt
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/nbd-wtf/go-nostr"
+)
+
+type captureLogger struct {
+	mu    sync.Mutex
+	lines []string
+}
+
+func (l *captureLogger) Printf(format string, v ...any) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.lines = append(l.lines, fmt.Sprintf(format, v...))
+}
+
+func (l *captureLogger) contains(s string) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for _, line := range l.lines {
+		if strings.Contains(line, s) {
+			return true
+		}
+	}
+	return false
+}
+
+func TestSlowMonitor(t *testing.T) {
+	logger := &captureLogger{}
+	s := &BoltBackend{Logger: logger, SlowThreshold: 10 * time.Millisecond}
+	defer s.stopMonitor()
+
+	done := s.track(func() string { return "slow operation" })
+	deadline := time.Now().Add(5 * time.Second)
+	for !logger.contains("slow operation has been running for") {
+		if time.Now().After(deadline) {
+			t.Fatal("slow operation was not logged")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	done()
+
+	s.track(func() string { return "fast operation" })()
+	time.Sleep(50 * time.Millisecond)
+	if logger.contains("fast operation") {
+		t.Error("fast operation was logged")
+	}
+}
+
+func TestSlowHooks(t *testing.T) {
+	f, _ := os.CreateTemp("", "")
+	f.Close()
+	defer os.Remove(f.Name())
+	var (
+		mu      sync.Mutex
+		saves   int
+		queries []QueryStats
+	)
+	s := &BoltBackend{
+		DatabaseURL:   f.Name(),
+		Logger:        &captureLogger{},
+		SlowThreshold: time.Nanosecond,
+		OnSlowSave: func(evt *nostr.Event, d time.Duration) {
+			mu.Lock()
+			defer mu.Unlock()
+			saves++
+		},
+		OnSlowQuery: func(filter *nostr.Filter, stats QueryStats) {
+			mu.Lock()
+			defer mu.Unlock()
+			queries = append(queries, stats)
+		},
+	}
+	s.Init()
+	defer s.stopMonitor()
+	s.DB.MaxBatchSize = 0
+
+	ctx := context.Background()
+	for i := 0; i < 3; i++ {
+		e := nostr.Event{
+			ID:        randHex(32),
+			PubKey:    randHex(32),
+			CreatedAt: nostr.Timestamp(rand.Int63n(1 << 32)),
+			Kind:      1,
+			Sig:       randHex(64),
+		}
+		if err := s.SaveEvent(ctx, &e); err != nil {
+			t.Fatal(err)
+		}
+	}
+	ch, err := s.QueryEvents(ctx, &nostr.Filter{Kinds: []int{1}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for range ch {
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if saves != 3 {
+		t.Errorf("OnSlowSave called %d times, want 3", saves)
+	}
+	if len(queries) != 1 {
+		t.Fatalf("OnSlowQuery called %d times, want 1", len(queries))
+	}
+	if queries[0].Emitted != 3 || queries[0].KeysScanned < 3 {
+		t.Errorf("unexpected query stats %+v", queries[0])
+	}
+}
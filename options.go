@@ -0,0 +1,83 @@
+package bolt
+
+import (
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// defaultOpenTimeout is used when Options.Timeout isn't set.
+const defaultOpenTimeout = time.Second
+
+// Options tunes the underlying bolt database. The zero value keeps the bolt
+// defaults, which favour durability. Init and Compact apply them whenever
+// the database file is opened.
+type Options struct {
+	// Timeout is how long to wait for the file lock held by another
+	// process, one second by default.
+	Timeout time.Duration
+	// NoSync skips fsync after each commit. A crash can then lose or
+	// corrupt recent writes, so only use it for data that can be rebuilt,
+	// such as bulk imports.
+	NoSync     bool
+	NoGrowSync bool
+	// NoFreelistSync keeps the freelist out of the file, making commits
+	// cheaper at the cost of scanning the file when it is opened.
+	NoFreelistSync bool
+	// FreelistType is bolt.FreelistArrayType by default. The map type is
+	// faster on large, fragmented files.
+	FreelistType bolt.FreelistType
+	// InitialMmapSize avoids remapping, which blocks writes while readers
+	// are open, until the file grows past it.
+	InitialMmapSize int
+	// PageSize only applies when the file is created. Zero uses the OS
+	// page size.
+	PageSize int
+	// MaxBatchSize and MaxBatchDelay bound how many concurrent writes are
+	// grouped into one commit and how long the first of them waits. Zero
+	// keeps the bolt defaults of 1000 and 10ms; a negative MaxBatchSize
+	// commits every write as soon as it arrives.
+	MaxBatchSize  int
+	MaxBatchDelay time.Duration
+	// ReadOnly opens the file with a shared lock, so several processes may
+	// read it at once but none may write. Init then skips creating buckets
+	// and every write fails with ErrReadOnly.
+	ReadOnly bool
+	// RefreshInterval makes a read-only backend check the file this often
+	// and Reopen it once it has been replaced by a new snapshot.
+	RefreshInterval time.Duration
+}
+
+func (o *Options) boltOptions() *bolt.Options {
+	opts := &bolt.Options{
+		Timeout:         o.Timeout,
+		NoGrowSync:      o.NoGrowSync,
+		NoFreelistSync:  o.NoFreelistSync,
+		FreelistType:    o.FreelistType,
+		ReadOnly:        o.ReadOnly,
+		InitialMmapSize: o.InitialMmapSize,
+		PageSize:        o.PageSize,
+		NoSync:          o.NoSync,
+	}
+	if opts.Timeout == 0 {
+		opts.Timeout = defaultOpenTimeout
+	}
+	return opts
+}
+
+// openDB opens the bolt file at path with the backend's options.
+func (b *BoltBackend) openDB(path string, readOnly bool) (*bolt.DB, error) {
+	opts := b.Options.boltOptions()
+	opts.ReadOnly = readOnly
+	db, err := bolt.Open(path, 0600, opts)
+	if err != nil {
+		return nil, err
+	}
+	if b.Options.MaxBatchSize != 0 {
+		db.MaxBatchSize = b.Options.MaxBatchSize
+	}
+	if b.Options.MaxBatchDelay != 0 {
+		db.MaxBatchDelay = b.Options.MaxBatchDelay
+	}
+	return db, nil
+}
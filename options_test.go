@@ -0,0 +1,115 @@
+package bolt
+
+import (
+	"context"
+	"math/rand"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/nbd-wtf/go-nostr"
+	bolt "go.etcd.io/bbolt"
+)
+
+func TestOptions(t *testing.T) {
+	f, _ := os.CreateTemp("", "")
+	f.Close()
+	os.Remove(f.Name())
+	defer os.Remove(f.Name())
+	s := &BoltBackend{DatabaseURL: f.Name(), Options: Options{
+		NoSync:         true,
+		NoFreelistSync: true,
+		FreelistType:   bolt.FreelistMapType,
+		PageSize:       8192,
+		MaxBatchSize:   -1,
+		MaxBatchDelay:  time.Millisecond,
+	}}
+	if err := s.Init(); err != nil {
+		t.Fatal(err)
+	}
+
+	check := func() {
+		t.Helper()
+		if s.DB.Info().PageSize != 8192 {
+			t.Errorf("got page size %d, want 8192", s.DB.Info().PageSize)
+		}
+		if !s.DB.NoSync || !s.DB.NoFreelistSync || s.DB.FreelistType != bolt.FreelistMapType {
+			t.Error("sync and freelist options were not applied")
+		}
+		if s.DB.MaxBatchSize != -1 || s.DB.MaxBatchDelay != time.Millisecond {
+			t.Errorf("got batch size %d and delay %s", s.DB.MaxBatchSize, s.DB.MaxBatchDelay)
+		}
+	}
+	check()
+	e := nostr.Event{ID: randHex(32), PubKey: randHex(32), CreatedAt: 1, Kind: 1, Sig: randHex(64)}
+	if err := s.SaveEvent(context.Background(), &e); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.Compact(); err != nil {
+		t.Fatal(err)
+	}
+	check()
+	s.DB.Close()
+
+	r := &BoltBackend{DatabaseURL: f.Name(), Options: Options{ReadOnly: true}}
+	if err := r.Init(); err != nil {
+		t.Fatal(err)
+	}
+	defer r.DB.Close()
+	if !r.DB.IsReadOnly() {
+		t.Error("database was opened for writing")
+	}
+	ch, _ := r.QueryEvents(context.Background(), &nostr.Filter{IDs: []string{e.ID}})
+	if n := len(collect(ch)); n != 1 {
+		t.Errorf("read %d events from the read-only database, want 1", n)
+	}
+}
+
+func collect(ch chan *nostr.Event) []*nostr.Event {
+	var events []*nostr.Event
+	for e := range ch {
+		events = append(events, e)
+	}
+	return events
+}
+
+func BenchmarkOptions(b *testing.B) {
+	presets := []struct {
+		name string
+		opts Options
+	}{
+		{"Default", Options{}},
+		{"NoSync", Options{NoSync: true}},
+		{"NoFreelistSync", Options{NoFreelistSync: true, FreelistType: bolt.FreelistMapType}},
+		{"Unbatched", Options{MaxBatchSize: -1}},
+		{"LargeMmap", Options{InitialMmapSize: 1 << 30}},
+	}
+	for _, p := range presets {
+		b.Run(p.name, func(b *testing.B) {
+			f, _ := os.CreateTemp("", "")
+			f.Close()
+			defer os.Remove(f.Name())
+			s := &BoltBackend{DatabaseURL: f.Name(), Options: p.opts}
+			if err := s.Init(); err != nil {
+				b.Fatal(err)
+			}
+			defer s.DB.Close()
+			ctx := context.Background()
+			b.ResetTimer()
+			b.RunParallel(func(pb *testing.PB) {
+				for pb.Next() {
+					e := nostr.Event{
+						ID:        randHex(32),
+						PubKey:    randHex(32),
+						CreatedAt: nostr.Timestamp(rand.Int63n(1 << 32)),
+						Kind:      rand.Intn(10),
+						Tags:      nostr.Tags{nostr.Tag{"p", randHex(32)}},
+						Content:   "arbitrary string",
+						Sig:       randHex(64),
+					}
+					s.SaveEvent(ctx, &e)
+				}
+			})
+		})
+	}
+}
@@ -3,164 +3,277 @@ package bolt
 import (
 	"bytes"
 	"context"
+	"encoding/binary"
 	"encoding/gob"
 	"errors"
-	"log"
+	"fmt"
 	"time"
 
+	"github.com/fiatjaf/relayer/v2"
 	"github.com/nbd-wtf/go-nostr"
 	bolt "go.etcd.io/bbolt"
 )
 
-func (b BoltBackend) QueryEvents(ctx context.Context, filter *nostr.Filter) (ch chan *nostr.Event, err error) {
+func (b *BoltBackend) QueryEvents(ctx context.Context, filter *nostr.Filter) (ch chan *nostr.Event, err error) {
+	ch, _, err = b.QueryEventsWithStats(ctx, filter)
+	return
+}
+
+// QueryEventsWithStats is like QueryEvents but also returns statistics about
+// the query, which can be read once the channel is closed. In particular
+// they report whether the query was cut short by MaxQueryKeys or
+// MaxQueryDuration.
+func (b *BoltBackend) QueryEventsWithStats(ctx context.Context, filter *nostr.Filter) (chan *nostr.Event, *QueryStats, error) {
+	stats := &QueryStats{}
+	if b.RestrictPrivateKinds {
+		pubkey, _ := relayer.GetAuthStatus(ctx)
+		ch, err := b.queryEvents(ctx, filter, &pubkey, stats, nil)
+		return ch, stats, err
+	}
+	ch, err := b.queryEvents(ctx, filter, nil, stats, nil)
+	return ch, stats, err
+}
+
+// QueryEventsAs is like QueryEvents but only returns private events, such as
+// NIP-04 direct messages and gift wraps, to their author or a p-tagged
+// recipient. pubkey is the NIP-42 authenticated pubkey of the requester, or
+// empty for unauthenticated clients.
+func (b *BoltBackend) QueryEventsAs(ctx context.Context, filter *nostr.Filter, pubkey string) (ch chan *nostr.Event, err error) {
+	return b.queryEvents(ctx, filter, &pubkey, &QueryStats{}, nil)
+}
+
+// queryEvents runs filter, skipping private events that requester may not
+// see unless requester is nil, and records how it went in stats. If ex isn't
+// nil the plan is also described there.
+func (b *BoltBackend) queryEvents(ctx context.Context, filter *nostr.Filter, requester *string, stats *QueryStats, ex *Explanation) (ch chan *nostr.Event, err error) {
 	full_ids, err := checkFilter(filter)
 	if err != nil {
-		log.Println("rejected query:", err, filter)
+		b.logf("rejected query %s: %v", describeFilter(filter), err)
 		return nil, nil
 	}
+	ctx, done, err := b.beginCancelable(ctx)
+	if err != nil {
+		return nil, err
+	}
 	idx := makeFilterIndexBytes(filter)
 	ch = make(chan *nostr.Event)
-	go b.DB.View(func(tx *bolt.Tx) error {
-		ctx, cancel := context.WithCancel(context.Background())
-		defer cancel()
-		go func() {
-			duration := time.Second
-			timer := time.NewTimer(duration)
-			start := time.Now()
-			for {
-				select {
-				case <-ctx.Done():
-					return // returning not to leak the goroutine
-				case now := <-timer.C:
-					duration *= 2
-					timer.Reset(duration)
-					log.Printf("query for %v has been running for %s", filter, now.Sub(start))
-				}
+	go b.viewBegun(func(tx *bolt.Tx) error {
+		defer done()
+		defer b.track(func() string { return "query for " + describeFilter(filter) })()
+		defer close(ch)
+		start := time.Now()
+		qb := b.newQueryBudget(ctx, stats)
+		defer func() {
+			stats.Duration = time.Since(start)
+			if stats.Truncated {
+				b.logf("query for %s truncated after scanning %d keys in %s", describeFilter(filter), stats.KeysScanned, stats.Duration)
+			}
+			b.metrics.countQuery(stats)
+			if stats.Duration >= b.slowThreshold() && b.OnSlowQuery != nil {
+				b.OnSlowQuery(filter, *stats)
 			}
 		}()
-		defer close(ch)
 		limit := filter.Limit
 		events := tx.Bucket([]byte("events"))
+		blocklist := newBlocklistChecker(tx)
+		// emit decodes and sends the event stored as v, reporting whether it
+		// was sent.
+		emit := func(v []byte) bool {
+			evt := nostr.Event{}
+			gob.NewDecoder(bytes.NewBuffer(v)).Decode(&evt)
+			stats.Decoded++
+			if blocklist.blocked(&evt) {
+				return false
+			}
+			if requester != nil && !canSeePrivate(&evt, *requester) {
+				return false
+			}
+			select {
+			case ch <- &evt:
+			case <-ctx.Done():
+				stats.Canceled = true
+				return false
+			}
+			stats.Emitted++
+			return true
+		}
 
 		switch {
 		// No filter:
 		case filter.IDs == nil && filter.Kinds == nil && filter.Authors == nil && len(filter.Tags) == 0:
-			c := tx.Bucket([]byte("timestamp_ids")).Cursor()
-			var k, v []byte
+			stats.Plan = PlanTimestamps
+			timestampIDs := tx.Bucket([]byte("timestamp_ids"))
+			ex.useBucket("timestamp_ids", timestampIDs)
+			c := qb.cursor(timestampIDs.Cursor())
+			var k []byte
 			if filter.Until != nil {
-				k, v = c.Seek(idx.Until)
-			} else {
-				k, v = c.Last()
+				k, _ = c.Seek(idx.Until)
+			}
+			if k == nil {
+				k, _ = c.Last()
 			}
-			for ; k != nil && limit > 0 && bytes.Compare(k, idx.Since) >= 0; k, v = c.Prev() {
-				limit -= 1
-				evt := nostr.Event{}
-				v = events.Get(k[8:len(k)])
-				gob.NewDecoder(bytes.NewBuffer(v)).Decode(&evt)
-				ch <- &evt
+			for ; k != nil && limit > 0 && !stats.Truncated && bytes.Compare(k, idx.Since) >= 0; k, _ = c.Prev() {
+				if idx.Until != nil && bytes.Compare(k[:8], idx.Until) >= 0 {
+					continue
+				}
+				if emit(events.Get(k[8:len(k)])) {
+					limit -= 1
+				}
 			}
 
 		// ID Filters (no prefix filters):
 		case filter.IDs != nil && full_ids && filter.Kinds == nil && filter.Authors == nil && len(filter.Tags) == 0:
+			stats.Plan = PlanIDs
+			ex.use("events", len(idx.IDs))
 			for _, id := range idx.IDs {
+				if !qb.spend() {
+					break
+				}
 				v := events.Get(id)
 				if v != nil {
-					evt := nostr.Event{}
-					gob.NewDecoder(bytes.NewBuffer(v)).Decode(&evt)
-					ch <- &evt
+					emit(v)
 				}
 			}
 
 		// ID Filters (allow prefix filters):
 		case filter.IDs != nil && filter.Kinds == nil && filter.Authors == nil && len(filter.Tags) == 0:
+			stats.Plan = PlanIDPrefixes
+			if ex != nil {
+				n := events.Stats().KeyN
+				for _, prefix := range idx.IDs {
+					// assume ids are evenly spread
+					estimate := n >> (8 * len(prefix))
+					if estimate == 0 {
+						estimate = 1
+					}
+					ex.use("events", estimate)
+				}
+			}
 			c := events.Cursor()
 			for _, prefix := range idx.IDs {
-				for k, v := c.Seek(prefix); limit > 0 && bytes.HasPrefix(k, prefix); k, v = c.Next() {
-					limit -= 1
-					evt := nostr.Event{}
-					gob.NewDecoder(bytes.NewBuffer(v)).Decode(&evt)
-					ch <- &evt
+				for k, v := c.Seek(prefix); limit > 0 && bytes.HasPrefix(k, prefix) && qb.spend(); k, v = c.Next() {
+					if emit(v) {
+						limit -= 1
+					}
 				}
 			}
 
 		// Non-id Filters:
 		case filter.IDs == nil:
-			andCursorSlice := make([]CursorLike, 0, 3)
-
-			if filter.Authors != nil && len(filter.Authors) > 0 {
-				b := tx.Bucket([]byte("authors"))
-				cs := make([]CursorLike, 0, len(filter.Authors))
-				for _, author := range idx.Authors {
-					sb := b.Bucket(author)
-					if sb != nil {
-						cs = append(cs, sb.Cursor())
-					}
-				}
-				if len(cs) == 0 { //no events match
-					return nil
-				}
-				andCursorSlice = append(andCursorSlice, makeOrCursor(cs))
+			stats.Plan = PlanIndexes
+			c := indexCursor(tx, filter, idx, qb, ex)
+			if c == nil {
+				return nil
 			}
 
-			for tagKey, tagValues := range idx.Tags {
-				if len(tagValues) == 0 {
+			var k []byte
+			if filter.Until != nil {
+				k, _ = c.Seek(idx.Until)
+			}
+			if k == nil {
+				k, _ = c.Last()
+			}
+			for ; k != nil && limit > 0 && !stats.Truncated && bytes.Compare(k, idx.Since) >= 0; k, _ = c.Prev() {
+				if idx.Until != nil && bytes.Compare(k[:8], idx.Until) >= 0 {
 					continue
 				}
-				tagBucket := tx.Bucket([]byte(tagKey))
-				if tagBucket == nil {
-					return nil
-				}
-				cs := make([]CursorLike, 0, len(tagValues))
-				for _, tagValue := range tagValues {
-					tagSubBucket := tagBucket.Bucket(tagValue)
-					if tagSubBucket != nil {
-						cs = append(cs, tagSubBucket.Cursor())
-					}
-				}
-				if len(cs) == 0 { //no events match
-					return nil
+				if emit(events.Get(k[8:len(k)])) {
+					limit -= 1
 				}
-				andCursorSlice = append(andCursorSlice, makeOrCursor(cs))
 			}
 
-			if filter.Kinds != nil && len(filter.Kinds) > 0 {
-				b := tx.Bucket([]byte("kinds"))
-				cs := make([]CursorLike, 0, len(filter.Kinds))
-				for _, kind := range idx.Kinds {
-					sb := b.Bucket(kind)
-					if sb != nil {
-						cs = append(cs, sb.Cursor())
-					}
-				}
-				if len(cs) == 0 { //no events match
-					return nil
-				}
-				andCursorSlice = append(andCursorSlice, makeOrCursor(cs))
-			}
+		default:
+			stats.Plan = PlanUnsupported
+		}
+		return nil
+	})
 
-			if len(andCursorSlice) == 0 {
-				return nil
+	return ch, nil
+}
+
+// indexCursor intersects the author, tag and kind indexes filter asks for,
+// with every move spending from qb unless it is nil. It returns nil when no
+// event can match.
+func indexCursor(tx *bolt.Tx, filter *nostr.Filter, idx *filterIndexBytes, qb *queryBudget, ex *Explanation) CursorLike {
+	andCursorSlice := make([]CursorLike, 0, 3)
+
+	if filter.Authors != nil && len(filter.Authors) > 0 {
+		b := tx.Bucket([]byte("authors"))
+		cs := make([]CursorLike, 0, len(filter.Authors))
+		for _, author := range idx.Authors {
+			sb := b.Bucket(author)
+			if sb != nil {
+				ex.useBucket(fmt.Sprintf("authors/%x", author), sb)
+				cs = append(cs, qb.cursor(sb.Cursor()))
 			}
-			c := makeAndCursor(andCursorSlice)
+		}
+		if len(cs) == 0 { //no events match
+			return nil
+		}
+		andCursorSlice = append(andCursorSlice, ex.orCursor(cs))
+	}
 
-			var k, v []byte
-			if filter.Until != nil {
-				k, _ = c.Seek(idx.Until)
-			} else {
-				k, _ = c.Last()
+	for tagKey, tagValues := range idx.Tags {
+		if len(tagValues) == 0 {
+			continue
+		}
+		tagBucket := tx.Bucket([]byte(tagKey))
+		if tagBucket == nil {
+			return nil
+		}
+		cs := make([]CursorLike, 0, len(tagValues))
+		for _, tagValue := range tagValues {
+			tagSubBucket := tagBucket.Bucket(tagValue)
+			if tagSubBucket != nil {
+				ex.useBucket(tagKey+"/"+string(tagValue), tagSubBucket)
+				cs = append(cs, qb.cursor(tagSubBucket.Cursor()))
 			}
-			for ; k != nil && limit > 0 && bytes.Compare(k, idx.Since) >= 0; k, _ = c.Prev() {
-				limit -= 1
-				evt := nostr.Event{}
-				v = events.Get(k[8:len(k)])
-				gob.NewDecoder(bytes.NewBuffer(v)).Decode(&evt)
-				ch <- &evt
+		}
+		if len(cs) == 0 { //no events match
+			return nil
+		}
+		andCursorSlice = append(andCursorSlice, ex.orCursor(cs))
+	}
+
+	if filter.Kinds != nil && len(filter.Kinds) > 0 {
+		b := tx.Bucket([]byte("kinds"))
+		cs := make([]CursorLike, 0, len(filter.Kinds))
+		for _, kind := range idx.Kinds {
+			sb := b.Bucket(kind)
+			if sb != nil {
+				ex.useBucket(fmt.Sprintf("kinds/%d", binary.BigEndian.Uint64(kind)), sb)
+				cs = append(cs, qb.cursor(sb.Cursor()))
 			}
 		}
+		if len(cs) == 0 { //no events match
+			return nil
+		}
+		andCursorSlice = append(andCursorSlice, ex.orCursor(cs))
+	}
+
+	if len(andCursorSlice) == 0 {
 		return nil
-	})
+	}
+	return makeAndCursor(andCursorSlice)
+}
 
-	return ch, nil
+// privateKinds are the kinds only returned to their author or recipients
+// by QueryEventsAs: NIP-04 direct messages and NIP-59 gift wraps.
+var privateKinds = map[int]struct{}{
+	4:    {},
+	1059: {},
+}
+
+// canSeePrivate reports whether requester may see evt, which is always true
+// for kinds not in privateKinds.
+func canSeePrivate(evt *nostr.Event, requester string) bool {
+	if _, ok := privateKinds[evt.Kind]; !ok {
+		return true
+	}
+	if requester == "" {
+		return false
+	}
+	return evt.PubKey == requester || evt.Tags.ContainsAny("p", []string{requester})
 }
 
 func checkFilter(filter *nostr.Filter) (full_ids bool, err error) {
@@ -275,6 +388,9 @@ func (oc *orCursor) Prev() (key, value []byte) {
 	return max, nil
 }
 
+// Seek moves every cursor to the first key not before seek, or to its last
+// key if they are all before seek, and returns the largest of them. Keys
+// after seek are left for the caller to skip.
 func (oc *orCursor) Seek(seek []byte) (key, value []byte) {
 	for k := range oc.emited {
 		delete(oc.emited, k)
@@ -282,6 +398,9 @@ func (oc *orCursor) Seek(seek []byte) (key, value []byte) {
 	var max []byte
 	for i, c := range oc.cursors {
 		k, _ := c.Seek(seek)
+		if k == nil {
+			k, _ = c.Last()
+		}
 		oc.keys[i] = k
 		if bytes.Compare(k, max) > 0 {
 			max = k
@@ -353,9 +472,13 @@ func (ac *andCursor) Prev() (key, value []byte) {
 	return min, nil
 }
 
+// Seek moves every cursor like orCursor.Seek and returns the largest key
+// they have in common at or before those positions.
 func (ac *andCursor) Seek(seek []byte) (key, value []byte) {
 	for i, c := range ac.cursors {
-		ac.keys[i], _ = c.Seek(seek)
+		if ac.keys[i], _ = c.Seek(seek); ac.keys[i] == nil {
+			ac.keys[i], _ = c.Last()
+		}
 	}
 	return ac.Prev()
 }
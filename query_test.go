@@ -5,6 +5,7 @@ import (
 	"math/rand"
 	"os"
 	"testing"
+	"time"
 
 	"github.com/fiatjaf/relayer/v2"
 	"github.com/fiatjaf/relayer/v2/storage/sqlite3"
@@ -260,6 +261,147 @@ func TestQueryEvents(t *testing.T) {
 	})
 }
 
+func TestQueryEventsAs(t *testing.T) {
+	f, _ := os.CreateTemp("", "")
+	f.Close()
+	defer os.Remove(f.Name())
+	s := &BoltBackend{DatabaseURL: f.Name()}
+	s.Init()
+	s.DB.MaxBatchSize = 0
+
+	ctx := context.Background()
+	alice, bob, carol := randHex(32), randHex(32), randHex(32)
+	for i := 0; i < 20; i++ {
+		e := nostr.Event{
+			ID:        randHex(32),
+			PubKey:    alice,
+			CreatedAt: nostr.Timestamp(int64(i)),
+			Kind:      1,
+			Content:   "arbitrary string",
+			Sig:       randHex(64),
+		}
+		if i%2 == 0 {
+			e.Kind = 4
+			e.Tags = nostr.Tags{nostr.Tag{"p", bob}}
+		}
+		s.SaveEvent(ctx, &e)
+	}
+
+	count := func(ch chan *nostr.Event, _ error) (n int) {
+		for range ch {
+			n++
+		}
+		return
+	}
+	filter := func() *nostr.Filter {
+		return &nostr.Filter{Kinds: []int{1, 4}, Limit: 15}
+	}
+	for pubkey, expected := range map[string]int{"": 10, alice: 15, bob: 15, carol: 10} {
+		if n := count(s.QueryEventsAs(ctx, filter(), pubkey)); n != expected {
+			t.Error("unexpected number of events", pubkey, n)
+		}
+	}
+	if n := count(s.QueryEvents(ctx, filter())); n != 15 {
+		t.Error("unexpected number of unrestricted events", n)
+	}
+
+	s.RestrictPrivateKinds = true
+	if n := count(s.QueryEvents(ctx, filter())); n != 10 {
+		t.Error("private events returned to unauthenticated client", n)
+	}
+	authed := context.WithValue(ctx, relayer.AUTH_CONTEXT_KEY, bob)
+	if n := count(s.QueryEvents(authed, filter())); n != 15 {
+		t.Error("private events not returned to recipient", n)
+	}
+}
+
+func TestQueryUntil(t *testing.T) {
+	f, _ := os.CreateTemp("", "")
+	f.Close()
+	defer os.Remove(f.Name())
+	s := &BoltBackend{DatabaseURL: f.Name()}
+	s.Init()
+	s.DB.MaxBatchSize = 0
+
+	ctx := context.Background()
+	pubkeys := []string{randHex(32), randHex(32)}
+	timestamps := []nostr.Timestamp{10, 20, 30, 30, 30}
+	for i, ts := range timestamps {
+		e := nostr.Event{ID: randHex(32), PubKey: pubkeys[i%2], CreatedAt: ts, Kind: 1, Sig: randHex(64)}
+		if err := s.SaveEvent(ctx, &e); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, until := range []nostr.Timestamp{5, 25, 30, 40} {
+		until := until
+		want := 0
+		for _, ts := range timestamps {
+			if ts <= until {
+				want++
+			}
+		}
+		for _, filter := range []nostr.Filter{
+			{Until: &until},
+			{Kinds: []int{1}, Until: &until},
+			{Authors: pubkeys, Until: &until},
+			{Authors: pubkeys, Kinds: []int{1}, Until: &until},
+		} {
+			ch, _ := s.QueryEvents(ctx, &filter)
+			events := collect(ch)
+			if len(events) != want {
+				t.Errorf("%v returned %d events, want %d", filter, len(events), want)
+			}
+			for _, e := range events {
+				if e.CreatedAt > until {
+					t.Errorf("%v returned an event created at %d", filter, e.CreatedAt)
+				}
+			}
+		}
+	}
+}
+
+func TestQueryBudget(t *testing.T) {
+	f, _ := os.CreateTemp("", "")
+	f.Close()
+	defer os.Remove(f.Name())
+	s := &BoltBackend{DatabaseURL: f.Name()}
+	s.Init()
+	s.DB.MaxBatchSize = 0
+	setupStorage([]relayer.Storage{s}, 1000)
+
+	ctx := context.Background()
+	ch, stats, _ := s.QueryEventsWithStats(ctx, &nostr.Filter{Limit: 100})
+	var i int
+	for range ch {
+		i++
+	}
+	if i != 100 || stats.Truncated || stats.Emitted != 100 {
+		t.Error("unexpected query stats", i, stats)
+	}
+
+	s.MaxQueryKeys = 50
+	ch, stats, _ = s.QueryEventsWithStats(ctx, &nostr.Filter{Limit: 100})
+	i = 0
+	for e := range ch {
+		i++
+		if e.CreatedAt < 1000-50 {
+			t.Error("event beyond budget", e.CreatedAt)
+		}
+	}
+	if i == 0 || i >= 100 || !stats.Truncated || stats.KeysScanned != 51 {
+		t.Error("query not truncated by key budget", i, stats)
+	}
+
+	s.MaxQueryKeys = 0
+	s.MaxQueryDuration = time.Nanosecond
+	ch, stats, _ = s.QueryEventsWithStats(ctx, &nostr.Filter{Kinds: []int{0, 1, 2, 3, 4, 5, 6, 7, 8, 9}, Limit: 100})
+	for range ch {
+	}
+	if !stats.Truncated || stats.Duration == 0 {
+		t.Error("query not truncated by time budget", stats)
+	}
+}
+
 func queryEvents(b *testing.B, s relayer.Storage, n int) {
 	ctx := context.Background()
 	limit := 50
@@ -0,0 +1,179 @@
+package bolt
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/gob"
+	"encoding/hex"
+	"errors"
+	"fmt"
+
+	"github.com/nbd-wtf/go-nostr"
+	bolt "go.etcd.io/bbolt"
+)
+
+// Usage is the amount of storage used by a single author.
+type Usage struct {
+	Events uint64
+	Bytes  uint64
+}
+
+// QuotaError is returned by SaveEvent when storing an event would take an
+// author over MaxAuthorEvents or MaxAuthorBytes.
+type QuotaError struct {
+	PubKey string
+	Usage  Usage
+	Limit  Usage
+}
+
+func (e *QuotaError) Error() string {
+	return fmt.Sprintf("blocked: author %s is over quota (%d/%d events, %d/%d bytes)",
+		e.PubKey, e.Usage.Events, e.Limit.Events, e.Usage.Bytes, e.Limit.Bytes)
+}
+
+func decodeUsage(v []byte) Usage {
+	if len(v) != 16 {
+		return Usage{}
+	}
+	return Usage{
+		Events: binary.BigEndian.Uint64(v[:8]),
+		Bytes:  binary.BigEndian.Uint64(v[8:]),
+	}
+}
+
+func encodeUsage(u Usage) []byte {
+	v := make([]byte, 16)
+	binary.BigEndian.PutUint64(v[:8], u.Events)
+	binary.BigEndian.PutUint64(v[8:], u.Bytes)
+	return v
+}
+
+// addUsage applies the signed deltas to pubkey's counters and removes the
+// entry once both counters reach zero. A delta taking a counter below zero
+// means the counters are out of sync with the events: the counter is
+// clamped at zero and underflow is set so the caller can report it.
+func addUsage(tx *bolt.Tx, pubkey []byte, events, bytes int64) (u Usage, underflow bool, err error) {
+	quotas := tx.Bucket([]byte("quotas"))
+	u = decodeUsage(quotas.Get(pubkey))
+	var eu, bu bool
+	u.Events, eu = addClamped(u.Events, events)
+	u.Bytes, bu = addClamped(u.Bytes, bytes)
+	if u.Events == 0 && u.Bytes == 0 {
+		return u, eu || bu, quotas.Delete(pubkey)
+	}
+	return u, eu || bu, quotas.Put(pubkey, encodeUsage(u))
+}
+
+func addClamped(x uint64, d int64) (uint64, bool) {
+	if d < 0 && uint64(-d) > x {
+		return 0, true
+	}
+	return x + uint64(d), false
+}
+
+// migrateQuotasBatchSize bounds the number of events counted per
+// transaction by migrateQuotas.
+const migrateQuotasBatchSize = 10000
+
+// migrateQuotas fills in the quotas bucket, which databases created before
+// usage was tracked have empty, by counting the stored events. The bucket
+// is cleared first so an interrupted migration can simply be run again.
+func migrateQuotas(b *BoltBackend) error {
+	if err := b.DB.Update(func(tx *bolt.Tx) error {
+		if err := tx.DeleteBucket([]byte("quotas")); err != nil && err != bolt.ErrBucketNotFound {
+			return err
+		}
+		_, err := tx.CreateBucket([]byte("quotas"))
+		return err
+	}); err != nil {
+		return err
+	}
+	var after []byte
+	for {
+		var n int
+		err := b.DB.Update(func(tx *bolt.Tx) error {
+			c := tx.Bucket([]byte("events")).Cursor()
+			k, v := c.First()
+			if after != nil {
+				if k, v = c.Seek(after); bytes.Equal(k, after) {
+					k, v = c.Next()
+				}
+			}
+			for ; k != nil && n < migrateQuotasBatchSize; k, v = c.Next() {
+				after = append(after[:0], k...)
+				n++
+				evt := nostr.Event{}
+				if err := gob.NewDecoder(bytes.NewBuffer(v)).Decode(&evt); err != nil {
+					continue
+				}
+				pubkey, err := hex.DecodeString(evt.PubKey)
+				if err != nil {
+					continue
+				}
+				if _, _, err := addUsage(tx, pubkey, 1, int64(len(v))); err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		if n < migrateQuotasBatchSize {
+			return nil
+		}
+	}
+}
+
+// checkQuota returns a *QuotaError if adding an event of size n would take
+// pubkey over the configured limits, counting the usage elsewhere on top of
+// the usage stored in this database.
+func (b *BoltBackend) checkQuota(tx *bolt.Tx, pubkey []byte, n int, elsewhere Usage) error {
+	if b.MaxAuthorEvents == 0 && b.MaxAuthorBytes == 0 {
+		return nil
+	}
+	u := decodeUsage(tx.Bucket([]byte("quotas")).Get(pubkey))
+	u.Events += elsewhere.Events
+	u.Bytes += elsewhere.Bytes
+	if (b.MaxAuthorEvents > 0 && u.Events+1 > b.MaxAuthorEvents) ||
+		(b.MaxAuthorBytes > 0 && u.Bytes+uint64(n) > b.MaxAuthorBytes) {
+		return &QuotaError{
+			PubKey: hex.EncodeToString(pubkey),
+			Usage:  u,
+			Limit:  Usage{Events: b.MaxAuthorEvents, Bytes: b.MaxAuthorBytes},
+		}
+	}
+	return nil
+}
+
+// AuthorUsage returns the number of events and bytes stored for pubkey.
+func (b *BoltBackend) AuthorUsage(pubkey string) (u Usage, err error) {
+	pubkeyb, err := hex.DecodeString(pubkey)
+	if err != nil || len(pubkeyb) != 32 {
+		return u, errors.New("invalid pubkey")
+	}
+	err = b.view(func(tx *bolt.Tx) error {
+		u = decodeUsage(tx.Bucket([]byte("quotas")).Get(pubkeyb))
+		return nil
+	})
+	return
+}
+
+// AdjustAuthorUsage adds the given deltas to pubkey's counters and returns
+// the new usage. Negative deltas can be used to grant an author more room
+// without raising the global limits, down to zero: a delta that would take
+// a counter below zero fails with ErrUsageUnderflow and changes nothing.
+func (b *BoltBackend) AdjustAuthorUsage(pubkey string, events, bytes int64) (u Usage, err error) {
+	pubkeyb, err := hex.DecodeString(pubkey)
+	if err != nil || len(pubkeyb) != 32 {
+		return u, errors.New("invalid pubkey")
+	}
+	err = b.update(func(tx *bolt.Tx) error {
+		var underflow bool
+		if u, underflow, err = addUsage(tx, pubkeyb, events, bytes); err == nil && underflow {
+			err = ErrUsageUnderflow
+		}
+		return err
+	})
+	return
+}
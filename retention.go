@@ -0,0 +1,188 @@
+package bolt
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// RetentionRule limits how long events with a kind in [MinKind, MaxKind]
+// are kept. MaxAge drops events older than the given duration and MaxCount
+// keeps only the newest MaxCount events of each kind. A zero value disables
+// the corresponding limit.
+type RetentionRule struct {
+	MinKind  int
+	MaxKind  int
+	MaxAge   time.Duration
+	MaxCount int
+}
+
+// PruneReport summarizes a call to Prune.
+type PruneReport struct {
+	DryRun  bool
+	Deleted int
+	ByKind  map[int]int
+}
+
+// pruneBatchSize bounds the number of events deleted per write transaction
+// so that pruning a large backlog doesn't block writers for too long.
+const pruneBatchSize = 1000
+
+// validate rejects rules whose kind range is empty or negative.
+func (r RetentionRule) validate() error {
+	if r.MinKind < 0 || r.MinKind > r.MaxKind {
+		return fmt.Errorf("invalid retention rule: kinds %d to %d", r.MinKind, r.MaxKind)
+	}
+	return nil
+}
+
+// Prune applies b.Retention, deleting expired events oldest first along with
+// all of their index entries. With dryRun set nothing is deleted and the
+// report lists what would have been, counting each event once even when
+// several rules match it.
+func (b *BoltBackend) Prune(ctx context.Context, dryRun bool) (*PruneReport, error) {
+	for _, rule := range b.Retention {
+		if err := rule.validate(); err != nil {
+			return nil, err
+		}
+	}
+	report := &PruneReport{DryRun: dryRun, ByKind: make(map[int]int)}
+	now := time.Now()
+	// pruned holds the keys a dry run would already have deleted, so later
+	// rules see the data as a real run would leave it
+	var pruned map[string]struct{}
+	if dryRun {
+		pruned = make(map[string]struct{})
+	}
+	for _, rule := range b.Retention {
+		if rule.MaxAge == 0 && rule.MaxCount == 0 {
+			continue
+		}
+		var kinds []int
+		if err := b.view(func(tx *bolt.Tx) error {
+			kinds = kindsInRange(tx, rule.MinKind, rule.MaxKind)
+			return nil
+		}); err != nil {
+			return report, err
+		}
+		for _, kind := range kinds {
+			if dryRun {
+				err := b.view(func(tx *bolt.Tx) error {
+					keys := pruneCandidates(tx, kind, rule, now, 0, pruned)
+					for _, k := range keys {
+						pruned[string(k)] = struct{}{}
+					}
+					n := len(keys)
+					report.Deleted += n
+					if n > 0 {
+						report.ByKind[kind] += n
+					}
+					return nil
+				})
+				if err != nil {
+					return report, err
+				}
+				continue
+			}
+			for {
+				if err := ctx.Err(); err != nil {
+					return report, err
+				}
+				var n int
+				err := b.update(func(tx *bolt.Tx) error {
+					keys := pruneCandidates(tx, kind, rule, now, pruneBatchSize, nil)
+					for _, k := range keys {
+						if err := b.pruneKey(tx, kind, k); err != nil {
+							return err
+						}
+					}
+					n = len(keys)
+					return nil
+				})
+				if err != nil {
+					return report, err
+				}
+				report.Deleted += n
+				if n > 0 {
+					report.ByKind[kind] += n
+				}
+				if n < pruneBatchSize {
+					break
+				}
+			}
+		}
+	}
+	return report, nil
+}
+
+func kindsInRange(tx *bolt.Tx, min, max int) []int {
+	var kinds []int
+	minb := make([]byte, 8)
+	binary.BigEndian.PutUint64(minb, uint64(min))
+	c := tx.Bucket([]byte("kinds")).Cursor()
+	for k, _ := c.Seek(minb); k != nil; k, _ = c.Next() {
+		kind := int(binary.BigEndian.Uint64(k))
+		if kind > max {
+			break
+		}
+		kinds = append(kinds, kind)
+	}
+	return kinds
+}
+
+// pruneCandidates returns up to limit TimestampIDs of kind that rule says
+// should be deleted, oldest first. A limit of 0 means no limit. Keys in
+// pruned are treated as already deleted.
+func pruneCandidates(tx *bolt.Tx, kind int, rule RetentionRule, now time.Time, limit int, pruned map[string]struct{}) [][]byte {
+	kindb := make([]byte, 8)
+	binary.BigEndian.PutUint64(kindb, uint64(kind))
+	kb := tx.Bucket([]byte("kinds")).Bucket(kindb)
+	if kb == nil {
+		return nil
+	}
+	var gone int
+	if len(pruned) > 0 {
+		kb.ForEach(func(k, _ []byte) error {
+			if _, ok := pruned[string(k)]; ok {
+				gone++
+			}
+			return nil
+		})
+	}
+	var excess int
+	if rule.MaxCount > 0 {
+		excess = kb.Stats().KeyN - gone - rule.MaxCount
+	}
+	var cutoff uint64
+	if rule.MaxAge > 0 {
+		cutoff = uint64(now.Add(-rule.MaxAge).Unix())
+	}
+	var keys [][]byte
+	c := kb.Cursor()
+	for k, _ := c.First(); k != nil && (limit == 0 || len(keys) < limit); k, _ = c.Next() {
+		if _, ok := pruned[string(k)]; ok {
+			continue
+		}
+		if len(keys) >= excess && binary.BigEndian.Uint64(k[:8]) >= cutoff {
+			break
+		}
+		keys = append(keys, append([]byte(nil), k...))
+	}
+	return keys
+}
+
+// pruneKey deletes the event referenced by the TimestampID k of kind. Index
+// entries whose event is gone or unreadable are dropped on their own.
+func (b *BoltBackend) pruneKey(tx *bolt.Tx, kind int, k []byte) error {
+	err := b.deleteEvent(tx, k[8:])
+	if !errors.Is(err, ErrEventNotFound) && !errors.Is(err, ErrCorruptIndex) {
+		return err
+	}
+	kindb := make([]byte, 8)
+	binary.BigEndian.PutUint64(kindb, uint64(kind))
+	return tx.Bucket([]byte("kinds")).Bucket(kindb).Delete(k)
+}
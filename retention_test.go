@@ -0,0 +1,83 @@
+package bolt
+
+import (
+	"context"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/nbd-wtf/go-nostr"
+)
+
+func TestPrune(t *testing.T) {
+	f, _ := os.CreateTemp("", "")
+	f.Close()
+	defer os.Remove(f.Name())
+	s := &BoltBackend{
+		DatabaseURL: f.Name(),
+		Retention: []RetentionRule{
+			{MinKind: 1, MaxKind: 1, MaxAge: 30 * 24 * time.Hour},
+			{MinKind: 7, MaxKind: 7, MaxCount: 2},
+			// overlaps the rules above, deleting nothing more
+			{MinKind: 0, MaxKind: 10, MaxAge: 45 * 24 * time.Hour},
+		},
+	}
+	s.Init()
+	s.DB.MaxBatchSize = 0
+
+	ctx := context.Background()
+	now := time.Now()
+	day := 24 * time.Hour
+	for i, age := range []time.Duration{1 * day, 10 * day, 40 * day, 50 * day} {
+		for _, kind := range []int{1, 7} {
+			e := nostr.Event{
+				ID:        randHex(32),
+				PubKey:    randHex(32),
+				CreatedAt: nostr.Timestamp(now.Add(-age).Unix()),
+				Kind:      kind,
+				Tags:      nostr.Tags{nostr.Tag{"p", randHex(32)}},
+				Content:   "arbitrary string",
+				Sig:       randHex(64),
+			}
+			if err := s.SaveEvent(ctx, &e); err != nil {
+				t.Fatal(i, err)
+			}
+		}
+	}
+
+	report, err := s.Prune(ctx, true)
+	if err != nil || report.Deleted != 4 || report.ByKind[1] != 2 || report.ByKind[7] != 2 {
+		t.Error("unexpected dry run report", report, err)
+	}
+
+	report, err = s.Prune(ctx, false)
+	if err != nil || report.Deleted != 4 || report.ByKind[1] != 2 || report.ByKind[7] != 2 {
+		t.Error("unexpected report", report, err)
+	}
+
+	for _, kind := range []int{1, 7} {
+		ch, _ := s.QueryEvents(ctx, &nostr.Filter{Kinds: []int{kind}})
+		var i int
+		for e := range ch {
+			i++
+			if now.Sub(e.CreatedAt.Time()) > 30*day {
+				t.Error("old event not pruned", e)
+			}
+		}
+		if i != 2 {
+			t.Error("unexpected number of events", kind, i)
+		}
+	}
+
+	report, err = s.Prune(ctx, false)
+	if err != nil || report.Deleted != 0 {
+		t.Error("second prune deleted events", report, err)
+	}
+
+	for _, rule := range []RetentionRule{{MinKind: -1, MaxKind: 1, MaxAge: day}, {MinKind: 2, MaxKind: 1, MaxAge: day}} {
+		s.Retention = []RetentionRule{rule}
+		if _, err := s.Prune(ctx, true); err == nil {
+			t.Error("invalid rule accepted", rule)
+		}
+	}
+}
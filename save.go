@@ -4,7 +4,6 @@ import (
 	"bytes"
 	"context"
 	"encoding/gob"
-	"log"
 	"time"
 
 	"github.com/nbd-wtf/go-nostr"
@@ -12,84 +11,132 @@ import (
 )
 
 func (b *BoltBackend) SaveEvent(ctx context.Context, evt *nostr.Event) error {
-	ctx, cancel := context.WithCancel(ctx)
-	defer cancel()
-	go func() {
-		duration := time.Second
-		timer := time.NewTimer(duration)
-		start := time.Now()
-		for {
-			select {
-			case <-ctx.Done():
-				return // returning not to leak the goroutine
-			case now := <-timer.C:
-				duration *= 2
-				timer.Reset(duration)
-				log.Printf("SaveEvent for %v has been running for %s", evt, now.Sub(start))
-			}
-		}
-	}()
-	idx := makeEventIndexBytes(evt)
-	alreadySaved := false
-	b.DB.View(func(tx *bolt.Tx) error {
-		events := tx.Bucket([]byte("events"))
-		if v := events.Get(idx.ID); v != nil {
-			alreadySaved = true
+	return b.saveEvent(ctx, evt, Usage{})
+}
+
+// saveEvent is SaveEvent for a database holding only some of the author's
+// events: elsewhere is the author's usage outside it, which counts against
+// the quota too.
+func (b *BoltBackend) saveEvent(ctx context.Context, evt *nostr.Event, elsewhere Usage) (err error) {
+	defer func() { b.metrics.countSave(err) }()
+	if b.Options.ReadOnly {
+		return ErrReadOnly
+	}
+	if b.VerifyEvents {
+		if err := validateEvent(evt); err != nil {
+			return err
 		}
-		return nil
-	})
-	if alreadySaved {
-		return nil
 	}
+	done, err := b.begin()
+	if err != nil {
+		return err
+	}
+	defer done()
+	start := time.Now()
+	defer b.track(func() string { return "SaveEvent for " + describeEvent(evt) })()
+	idx := makeEventIndexBytes(evt)
 	var evtBuffer bytes.Buffer
 	gob.NewEncoder(&evtBuffer).Encode(evt)
 	evtBytes := evtBuffer.Bytes()
-	return b.DB.Update(func(tx *bolt.Tx) error {
-		events := tx.Bucket([]byte("events"))
-		if err := events.Put(idx.ID, evtBytes); err != nil {
-			return err
+	// The batch function may be re-run, so alreadySaved, full and rejected
+	// are reset on every call. Rejections are returned after the batch
+	// rather than from it, since an error would roll back and re-run every
+	// other save sharing the transaction.
+	var alreadySaved, full bool
+	var rejected error
+	var size int64
+	err = b.batch(func(tx *bolt.Tx) error {
+		alreadySaved, full, rejected = false, false, nil
+		size = tx.Size()
+		// blocked and deleted events are rejected even if they are stored
+		if newBlocklistChecker(tx).blocked(evt) {
+			rejected = ErrBlocked
+			return nil
 		}
-		authors := tx.Bucket([]byte("authors"))
-		author, err := authors.CreateBucketIfNotExists(idx.PubKey)
-		if err != nil {
-			return err
+		if rejected = b.checkTombstones(tx, evt, idx.ID); rejected != nil {
+			return nil
 		}
-		if err := author.Put(idx.TimestampID, nil); err != nil {
-			return err
+		events := tx.Bucket([]byte("events"))
+		if v := events.Get(idx.ID); v != nil {
+			alreadySaved = true
+			return nil
 		}
-		kinds := tx.Bucket([]byte("kinds"))
-		kind, err := kinds.CreateBucketIfNotExists(idx.Kind)
-		if err != nil {
-			return err
+		if b.MaxSize > 0 && size >= b.MaxSize {
+			full = true
+			return nil
+		}
+		if rejected = b.checkQuota(tx, idx.PubKey, len(evtBytes), elsewhere); rejected != nil {
+			return nil
 		}
-		if err := kind.Put(idx.TimestampID, nil); err != nil {
+		if err := events.Put(idx.ID, evtBytes); err != nil {
 			return err
 		}
-		timestamps := tx.Bucket([]byte("timestamps"))
-		if err := timestamps.Put(idx.ID, idx.Timestamp); err != nil {
+		if _, _, err := addUsage(tx, idx.PubKey, 1, int64(len(evtBytes))); err != nil {
 			return err
 		}
-		timestamp_ids := tx.Bucket([]byte("timestamp_ids"))
-		if err := timestamp_ids.Put(idx.TimestampID, nil); err != nil {
+		return putEventIndexes(tx, idx)
+	})
+	if err != nil {
+		return err
+	}
+	if rejected != nil {
+		return rejected
+	}
+	if alreadySaved {
+		return ErrDupEvent
+	}
+	b.maybeEvict(size)
+	if full {
+		return ErrDatabaseFull
+	}
+	if d := time.Since(start); d >= b.slowThreshold() && b.OnSlowSave != nil {
+		b.OnSlowSave(evt, d)
+	}
+	return nil
+}
+
+// putEventIndexes adds the index entries for an event to every index bucket.
+func putEventIndexes(tx *bolt.Tx, idx *eventIndexBytes) error {
+	authors := tx.Bucket([]byte("authors"))
+	author, err := authors.CreateBucketIfNotExists(idx.PubKey)
+	if err != nil {
+		return err
+	}
+	if err := author.Put(idx.TimestampID, nil); err != nil {
+		return err
+	}
+	kinds := tx.Bucket([]byte("kinds"))
+	kind, err := kinds.CreateBucketIfNotExists(idx.Kind)
+	if err != nil {
+		return err
+	}
+	if err := kind.Put(idx.TimestampID, nil); err != nil {
+		return err
+	}
+	timestamps := tx.Bucket([]byte("timestamps"))
+	if err := timestamps.Put(idx.ID, idx.Timestamp); err != nil {
+		return err
+	}
+	timestamp_ids := tx.Bucket([]byte("timestamp_ids"))
+	if err := timestamp_ids.Put(idx.TimestampID, nil); err != nil {
+		return err
+	}
+	for tagKey, tagValues := range idx.Tags {
+		tagBucket, err := tx.CreateBucketIfNotExists([]byte(tagKey))
+		if err != nil {
 			return err
 		}
-		for tagKey, tagValues := range idx.Tags {
-			tagBucket, err := tx.CreateBucketIfNotExists([]byte(tagKey))
+		for _, tagValue := range tagValues {
+			tagSubBucket, err := tagBucket.CreateBucketIfNotExists(tagValue)
 			if err != nil {
 				return err
 			}
-			for _, tagValue := range tagValues {
-				tagSubBucket, err := tagBucket.CreateBucketIfNotExists(tagValue)
-				if err != nil {
-					return err
-				}
-				if err := tagSubBucket.Put(idx.TimestampID, nil); err != nil {
-					return err
-				}
+			if err := tagSubBucket.Put(idx.TimestampID, nil); err != nil {
+				return err
 			}
 		}
-		return nil
-	})
+	}
+	return nil
 }
 
 func (b *BoltBackend) BeforeSave(ctx context.Context, evt *nostr.Event) {
@@ -2,14 +2,17 @@ package bolt
 
 import (
 	"context"
+	"errors"
 	"math/rand"
 	"os"
+	"sync"
 	"testing"
 	"time"
 
 	"github.com/fiatjaf/relayer/v2"
 	"github.com/fiatjaf/relayer/v2/storage/sqlite3"
 	"github.com/nbd-wtf/go-nostr"
+	bolt "go.etcd.io/bbolt"
 )
 
 func TestSaveEvent(t *testing.T) {
@@ -51,6 +54,196 @@ func TestSaveEvent(t *testing.T) {
 	}
 }
 
+func TestSaveEventConcurrentDuplicates(t *testing.T) {
+	f, _ := os.CreateTemp("", "")
+	f.Close()
+	defer os.Remove(f.Name())
+	s := &BoltBackend{DatabaseURL: f.Name()}
+	s.Init()
+
+	ctx := context.Background()
+	e := nostr.Event{
+		ID:        randHex(32),
+		PubKey:    randHex(32),
+		CreatedAt: nostr.Timestamp(rand.Int63()),
+		Kind:      rand.Intn(10),
+		Tags:      nostr.Tags{nostr.Tag{"p", randHex(32)}},
+		Content:   "arbitrary string",
+		Sig:       randHex(64),
+	}
+
+	n := 20
+	errs := make(chan error, n)
+	var wg sync.WaitGroup
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			evt := e
+			errs <- s.SaveEvent(ctx, &evt)
+		}()
+	}
+	wg.Wait()
+	close(errs)
+
+	var saved, dups int
+	for err := range errs {
+		switch err {
+		case nil:
+			saved++
+		case ErrDupEvent:
+			dups++
+		default:
+			t.Error("unexpected error", err)
+		}
+	}
+	if saved != 1 || dups != n-1 {
+		t.Error("unexpected save results", saved, dups)
+	}
+
+	ch, _ := s.QueryEvents(ctx, &nostr.Filter{Authors: []string{e.PubKey}})
+	var i int
+	for range ch {
+		i++
+	}
+	if i != 1 {
+		t.Error("unexpected number of events", i)
+	}
+}
+
+func TestSaveEventVerify(t *testing.T) {
+	f, _ := os.CreateTemp("", "")
+	f.Close()
+	defer os.Remove(f.Name())
+	s := &BoltBackend{DatabaseURL: f.Name(), VerifyEvents: true}
+	s.Init()
+	s.DB.MaxBatchSize = 0
+
+	ctx := context.Background()
+	sk := nostr.GeneratePrivateKey()
+	newEvent := func() nostr.Event {
+		e := nostr.Event{
+			CreatedAt: nostr.Timestamp(rand.Int63n(1 << 32)),
+			Kind:      1,
+			Tags:      nostr.Tags{nostr.Tag{"p", randHex(32)}},
+			Content:   "arbitrary string",
+		}
+		e.Sign(sk)
+		return e
+	}
+
+	e := newEvent()
+	if err := s.SaveEvent(ctx, &e); err != nil {
+		t.Error("valid event rejected", err)
+	}
+
+	e = newEvent()
+	e.ID = "abc"
+	if err := s.SaveEvent(ctx, &e); !errors.Is(err, ErrInvalidEvent) {
+		t.Error("short id accepted", err)
+	}
+
+	e = newEvent()
+	e.PubKey = "zz" + e.PubKey[2:]
+	if err := s.SaveEvent(ctx, &e); !errors.Is(err, ErrInvalidEvent) {
+		t.Error("non-hex pubkey accepted", err)
+	}
+
+	e = newEvent()
+	e.Content = "tampered"
+	if err := s.SaveEvent(ctx, &e); !errors.Is(err, ErrInvalidEvent) {
+		t.Error("tampered content accepted", err)
+	}
+
+	e = newEvent()
+	e2 := newEvent()
+	e.Sig = e2.Sig
+	if err := s.SaveEvent(ctx, &e); !errors.Is(err, ErrInvalidEvent) {
+		t.Error("bad signature accepted", err)
+	}
+}
+
+func TestSaveEventQuota(t *testing.T) {
+	f, _ := os.CreateTemp("", "")
+	f.Close()
+	defer os.Remove(f.Name())
+	s := &BoltBackend{DatabaseURL: f.Name(), MaxAuthorEvents: 3}
+	s.Init()
+	s.DB.MaxBatchSize = 0
+
+	ctx := context.Background()
+	pubkey := randHex(32)
+	newEvent := func() nostr.Event {
+		return nostr.Event{
+			ID:        randHex(32),
+			PubKey:    pubkey,
+			CreatedAt: nostr.Timestamp(rand.Int63()),
+			Kind:      rand.Intn(10),
+			Content:   "arbitrary string",
+			Sig:       randHex(64),
+		}
+	}
+
+	for i := 0; i < 3; i++ {
+		e := newEvent()
+		if err := s.SaveEvent(ctx, &e); err != nil {
+			t.Error("event under quota rejected", err)
+		}
+	}
+	e := newEvent()
+	var qerr *QuotaError
+	if err := s.SaveEvent(ctx, &e); !errors.As(err, &qerr) {
+		t.Error("event over quota accepted", err)
+	} else if qerr.Usage.Events != 3 || qerr.PubKey != pubkey {
+		t.Error("unexpected quota error", qerr)
+	}
+
+	u, err := s.AuthorUsage(pubkey)
+	if err != nil || u.Events != 3 || u.Bytes == 0 {
+		t.Error("unexpected usage", u, err)
+	}
+
+	if _, err := s.AdjustAuthorUsage(pubkey, -1, 0); err != nil {
+		t.Error(err)
+	}
+	if err := s.SaveEvent(ctx, &e); err != nil {
+		t.Error("event rejected after adjusting usage", err)
+	}
+	if _, err := s.AdjustAuthorUsage(pubkey, -4, 0); err != ErrUsageUnderflow {
+		t.Error("adjusted usage below zero", err)
+	}
+	if u, _ := s.AuthorUsage(pubkey); u.Events != 3 {
+		t.Error("usage changed by a failed adjustment", u)
+	}
+}
+
+func TestMigrateQuotas(t *testing.T) {
+	f, _ := os.CreateTemp("", "")
+	f.Close()
+	defer os.Remove(f.Name())
+	s := &BoltBackend{DatabaseURL: f.Name()}
+	s.Init()
+	s.DB.MaxBatchSize = 0
+	setupStorage([]relayer.Storage{s}, 100)
+
+	// rewrite the database the way version 2 left it, without usage
+	s.DB.Update(func(tx *bolt.Tx) error {
+		tx.DeleteBucket([]byte("quotas"))
+		tx.CreateBucket([]byte("quotas"))
+		return writeSchemaVersion(tx, 2)
+	})
+	s.DB.Close()
+
+	s = &BoltBackend{DatabaseURL: f.Name()}
+	if err := s.Init(); err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close(context.Background())
+	if r, err := s.Verify(context.Background()); err != nil || !r.OK() {
+		t.Error("usage not recounted by the migration", r.Problems, err)
+	}
+}
+
 func saveEvents(b *testing.B, s relayer.Storage) {
 	ctx := context.Background()
 	b.ResetTimer()
@@ -0,0 +1,111 @@
+package bolt
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// schemaVersion is the version of the bucket layout written by this package.
+// It is stored under "version" in the "meta" bucket.
+const schemaVersion = 3
+
+// migrations[v] upgrades a database from version v to v+1.
+var migrations = map[uint64]func(b *BoltBackend) error{
+	1: migrateTimestamps,
+	2: migrateQuotas,
+}
+
+// migrateSchema runs the migrations needed to bring the database up to
+// schemaVersion, recording the version after each one.
+func (b *BoltBackend) migrateSchema() error {
+	for {
+		var version uint64
+		b.DB.View(func(tx *bolt.Tx) error {
+			version, _ = readSchemaVersion(tx)
+			return nil
+		})
+		if version >= schemaVersion {
+			return nil
+		}
+		migration, ok := migrations[version]
+		if !ok {
+			return fmt.Errorf("no migration from schema version %d", version)
+		}
+		if err := migration(b); err != nil {
+			return fmt.Errorf("migrating from schema version %d: %w", version, err)
+		}
+		if err := b.DB.Update(func(tx *bolt.Tx) error {
+			return writeSchemaVersion(tx, version+1)
+		}); err != nil {
+			return err
+		}
+	}
+}
+
+// migrateTimestampsBatchSize bounds the number of entries repaired per
+// transaction by migrateTimestamps.
+const migrateTimestampsBatchSize = 10000
+
+// migrateTimestamps fills in the timestamps bucket, which version 1 wrote
+// with empty values, from the keys of the timestamp_ids bucket.
+func migrateTimestamps(b *BoltBackend) error {
+	var after []byte
+	for {
+		var n int
+		err := b.DB.Update(func(tx *bolt.Tx) error {
+			timestamps := tx.Bucket([]byte("timestamps"))
+			c := tx.Bucket([]byte("timestamp_ids")).Cursor()
+			k, _ := c.First()
+			if after != nil {
+				if k, _ = c.Seek(after); bytes.Equal(k, after) {
+					k, _ = c.Next()
+				}
+			}
+			for ; k != nil && n < migrateTimestampsBatchSize; k, _ = c.Next() {
+				after = append(after[:0], k...)
+				n++
+				if len(k) != 8+32 {
+					continue
+				}
+				if err := timestamps.Put(k[8:], k[:8]); err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		if n < migrateTimestampsBatchSize {
+			return nil
+		}
+	}
+}
+
+// readSchemaVersion returns the layout version recorded in tx. Databases
+// created before the version was recorded report version 1 as long as they
+// have an events bucket.
+func readSchemaVersion(tx *bolt.Tx) (version uint64, ok bool) {
+	if meta := tx.Bucket([]byte("meta")); meta != nil {
+		if v := meta.Get([]byte("version")); len(v) == 8 {
+			return binary.BigEndian.Uint64(v), true
+		}
+	}
+	if tx.Bucket([]byte("events")) != nil {
+		return 1, true
+	}
+	return 0, false
+}
+
+func writeSchemaVersion(tx *bolt.Tx, version uint64) error {
+	meta, err := tx.CreateBucketIfNotExists([]byte("meta"))
+	if err != nil {
+		return err
+	}
+	v := make([]byte, 8)
+	binary.BigEndian.PutUint64(v, version)
+	return meta.Put([]byte("version"), v)
+}
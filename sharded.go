@@ -0,0 +1,538 @@
+package bolt
+
+import (
+	"context"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/nbd-wtf/go-nostr"
+	bolt "go.etcd.io/bbolt"
+)
+
+// partitionLayout names partition files after the month they cover.
+const partitionLayout = "2006-01"
+
+// defaultMinCreatedAt is used when MinCreatedAt isn't set. No real nostr
+// event is older.
+var defaultMinCreatedAt = time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+
+// defaultMaxClockSkew is used when MaxClockSkew isn't set.
+const defaultMaxClockSkew = time.Hour
+
+// ShardedBackend stores events in one BoltBackend per calendar month of
+// their created_at, in files named like 2023-08.db under Dir. Each
+// partition uses the same layout as a standalone database, so old months
+// can be dropped by deleting their file.
+//
+// The blocklist, allowlist and tombstones are kept in every partition:
+// ShardedBackend's methods for them change all of them, and a new partition
+// starts with a copy of the newest one's. Quotas set with Configure limit an
+// author's events across every partition, although concurrent saves by one
+// author into different months are each checked against the usage stored
+// before them.
+type ShardedBackend struct {
+	Dir string
+	// Configure, if set, is called on each partition before it is
+	// initialized, to apply the settings of BoltBackend other than
+	// DatabaseURL.
+	Configure func(b *BoltBackend)
+	// SaveEvent rejects events created before MinCreatedAt, 2020 by
+	// default, or more than MaxClockSkew, one hour by default, in the
+	// future, so clients can't create partitions at will.
+	MinCreatedAt time.Time
+	MaxClockSkew time.Duration
+
+	mu         sync.RWMutex
+	partitions map[string]*BoltBackend
+	// dropping holds the names of the partitions DropBefore is closing,
+	// which must not be reopened until their files are gone.
+	dropping map[string]struct{}
+}
+
+// partition is an open partition along with the month it covers.
+type partition struct {
+	*BoltBackend
+	name       string
+	start, end nostr.Timestamp
+}
+
+// partitionName returns the name of the partition holding events created
+// at ts.
+func partitionName(ts nostr.Timestamp) string {
+	return time.Unix(int64(ts), 0).UTC().Format(partitionLayout)
+}
+
+// partitionRange returns the first and last second covered by the named
+// partition.
+func partitionRange(name string) (start, end nostr.Timestamp, err error) {
+	t, err := time.Parse(partitionLayout, name)
+	if err != nil {
+		return 0, 0, err
+	}
+	return nostr.Timestamp(t.Unix()), nostr.Timestamp(t.AddDate(0, 1, 0).Unix() - 1), nil
+}
+
+func (s *ShardedBackend) Init() error {
+	if err := os.MkdirAll(s.Dir, 0700); err != nil {
+		return err
+	}
+	paths, err := filepath.Glob(filepath.Join(s.Dir, "*.db"))
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.partitions = make(map[string]*BoltBackend, len(paths))
+	for _, path := range paths {
+		name := strings.TrimSuffix(filepath.Base(path), ".db")
+		if _, _, err := partitionRange(name); err != nil {
+			continue
+		}
+		if _, err := s.open(name); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// open opens the named partition, creating its file if needed. The caller
+// must hold mu.
+func (s *ShardedBackend) open(name string) (*BoltBackend, error) {
+	if _, _, err := partitionRange(name); err != nil {
+		return nil, fmt.Errorf("invalid partition name %q: %w", name, err)
+	}
+	if _, ok := s.dropping[name]; ok {
+		return nil, ErrDropping
+	}
+	path := filepath.Join(s.Dir, name+".db")
+	_, err := os.Stat(path)
+	created := os.IsNotExist(err)
+	b := &BoltBackend{}
+	if s.Configure != nil {
+		s.Configure(b)
+	}
+	b.DatabaseURL = path
+	if err := b.Init(); err != nil {
+		return nil, err
+	}
+	if src := s.newest(); created && src != nil {
+		if err := copyControls(b, src); err != nil {
+			b.Close(context.Background())
+			os.Remove(path)
+			return nil, err
+		}
+	}
+	s.partitions[name] = b
+	return b, nil
+}
+
+// partitionFor returns the named partition, opening it if needed.
+func (s *ShardedBackend) partitionFor(name string) (*BoltBackend, error) {
+	s.mu.RLock()
+	b := s.partitions[name]
+	s.mu.RUnlock()
+	if b != nil {
+		return b, nil
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if b = s.partitions[name]; b != nil {
+		return b, nil
+	}
+	return s.open(name)
+}
+
+// newest returns the newest open partition, or nil if there is none. The
+// caller must hold mu.
+func (s *ShardedBackend) newest() *BoltBackend {
+	var newest string
+	for name := range s.partitions {
+		if name > newest {
+			newest = name
+		}
+	}
+	return s.partitions[newest]
+}
+
+// controlBuckets are the buckets kept the same in every partition.
+var controlBuckets = []string{"blocklist", "allowlist", "tombstones"}
+
+// copyControls copies the blocklist, allowlist and tombstones of src into
+// dst.
+func copyControls(dst, src *BoltBackend) error {
+	return src.view(func(stx *bolt.Tx) error {
+		return dst.update(func(dtx *bolt.Tx) error {
+			for _, name := range controlBuckets {
+				if err := stx.Bucket([]byte(name)).ForEach(dtx.Bucket([]byte(name)).Put); err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+	})
+}
+
+// eachPartition calls fn on every open partition, first creating the
+// current month's if there is none so that the change is kept. It holds mu
+// so that no partition can be created meanwhile by copying one fn hasn't
+// reached yet. Partitions closed meanwhile are skipped.
+func (s *ShardedBackend) eachPartition(fn func(b *BoltBackend) error) error {
+	if len(s.Partitions()) == 0 {
+		if _, err := s.partitionFor(partitionName(nostr.Now())); err != nil {
+			return err
+		}
+	}
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	for _, b := range s.partitions {
+		if err := fn(b); err != nil && !errors.Is(err, ErrClosed) {
+			return err
+		}
+	}
+	return nil
+}
+
+// Partitions returns the names of the open partitions, newest first.
+func (s *ShardedBackend) Partitions() []string {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	names := make([]string, 0, len(s.partitions))
+	for name := range s.partitions {
+		names = append(names, name)
+	}
+	sort.Sort(sort.Reverse(sort.StringSlice(names)))
+	return names
+}
+
+// newestFirst returns the open partitions, newest first.
+func (s *ShardedBackend) newestFirst() []partition {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	ps := make([]partition, 0, len(s.partitions))
+	for name, b := range s.partitions {
+		start, end, _ := partitionRange(name)
+		ps = append(ps, partition{b, name, start, end})
+	}
+	sort.Slice(ps, func(i, j int) bool { return ps[i].start > ps[j].start })
+	return ps
+}
+
+// acceptedRange returns the creation times SaveEvent accepts.
+func (s *ShardedBackend) acceptedRange() (min, max nostr.Timestamp) {
+	minTime := s.MinCreatedAt
+	if minTime.IsZero() {
+		minTime = defaultMinCreatedAt
+	}
+	skew := s.MaxClockSkew
+	if skew <= 0 {
+		skew = defaultMaxClockSkew
+	}
+	return nostr.Timestamp(minTime.Unix()), nostr.Timestamp(time.Now().Add(skew).Unix())
+}
+
+func (s *ShardedBackend) SaveEvent(ctx context.Context, evt *nostr.Event) error {
+	if min, max := s.acceptedRange(); evt.CreatedAt < min || evt.CreatedAt > max {
+		return ErrOutOfRange
+	}
+	b, err := s.partitionFor(partitionName(evt.CreatedAt))
+	if err != nil {
+		return err
+	}
+	var elsewhere Usage
+	if b.MaxAuthorEvents > 0 || b.MaxAuthorBytes > 0 {
+		if pubkeyb, err := hex.DecodeString(evt.PubKey); err == nil && len(pubkeyb) == 32 {
+			if elsewhere, err = s.usage(pubkeyb, b); err != nil {
+				return err
+			}
+		}
+	}
+	return b.saveEvent(ctx, evt, elsewhere)
+}
+
+// usage sums the usage of pubkey in every partition but except.
+func (s *ShardedBackend) usage(pubkey []byte, except *BoltBackend) (u Usage, err error) {
+	for _, p := range s.newestFirst() {
+		if p.BoltBackend == except {
+			continue
+		}
+		err := p.view(func(tx *bolt.Tx) error {
+			pu := decodeUsage(tx.Bucket([]byte("quotas")).Get(pubkey))
+			u.Events += pu.Events
+			u.Bytes += pu.Bytes
+			return nil
+		})
+		if err != nil && !errors.Is(err, ErrClosed) {
+			return u, err
+		}
+	}
+	return u, nil
+}
+
+// AuthorUsage returns the number of events and bytes stored for pubkey in
+// every partition.
+func (s *ShardedBackend) AuthorUsage(pubkey string) (Usage, error) {
+	pubkeyb, err := hex.DecodeString(pubkey)
+	if err != nil || len(pubkeyb) != 32 {
+		return Usage{}, errors.New("invalid pubkey")
+	}
+	return s.usage(pubkeyb, nil)
+}
+
+// Block adds value to the blocklist of every partition, see
+// BoltBackend.Block. With purge set, the matching events are deleted from
+// every partition.
+func (s *ShardedBackend) Block(ctx context.Context, t BlockType, value string, purge bool) (purged int, err error) {
+	if err := s.eachPartition(func(b *BoltBackend) error {
+		_, err := b.Block(ctx, t, value, false)
+		return err
+	}); err != nil {
+		return 0, err
+	}
+	if !purge {
+		return 0, nil
+	}
+	// purging can take a while, so it is done without holding mu
+	for _, p := range s.newestFirst() {
+		n, err := p.Block(ctx, t, value, true)
+		purged += n
+		if err != nil && !errors.Is(err, ErrClosed) {
+			return purged, err
+		}
+	}
+	return purged, nil
+}
+
+// Unblock removes value from the blocklist of every partition.
+func (s *ShardedBackend) Unblock(t BlockType, value string) error {
+	return s.eachPartition(func(b *BoltBackend) error {
+		return b.Unblock(t, value)
+	})
+}
+
+// Blocked lists the hex values on the blocklist with type t.
+func (s *ShardedBackend) Blocked(t BlockType) ([]string, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if b := s.newest(); b != nil {
+		return b.Blocked(t)
+	}
+	return nil, nil
+}
+
+// Allow adds pubkey to the allowlist of every partition, see
+// BoltBackend.Allow.
+func (s *ShardedBackend) Allow(pubkey string, expires time.Time) error {
+	return s.eachPartition(func(b *BoltBackend) error {
+		return b.Allow(pubkey, expires)
+	})
+}
+
+// Disallow removes pubkey from the allowlist of every partition.
+func (s *ShardedBackend) Disallow(pubkey string) error {
+	return s.eachPartition(func(b *BoltBackend) error {
+		return b.Disallow(pubkey)
+	})
+}
+
+// Allowlist returns every entry on the allowlist, including expired ones.
+func (s *ShardedBackend) Allowlist() ([]AllowlistEntry, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if b := s.newest(); b != nil {
+		return b.Allowlist()
+	}
+	return nil, nil
+}
+
+// IsAllowed reports whether pubkey is on the allowlist and hasn't expired.
+func (s *ShardedBackend) IsAllowed(pubkey string) bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if b := s.newest(); b != nil {
+		return b.IsAllowed(pubkey)
+	}
+	return false
+}
+
+// RemoveExpiredAllowlistEntries drops expired entries from the allowlist of
+// every partition and returns the largest number removed from one.
+func (s *ShardedBackend) RemoveExpiredAllowlistEntries() (n int, err error) {
+	err = s.eachPartition(func(b *BoltBackend) error {
+		m, err := b.RemoveExpiredAllowlistEntries()
+		if m > n {
+			n = m
+		}
+		return err
+	})
+	return
+}
+
+// ClearTombstones removes old tombstones from every partition, see
+// BoltBackend.ClearTombstones, and returns the largest number removed from
+// one.
+func (s *ShardedBackend) ClearTombstones(olderThan time.Duration) (n int, err error) {
+	err = s.eachPartition(func(b *BoltBackend) error {
+		m, err := b.ClearTombstones(olderThan)
+		if m > n {
+			n = m
+		}
+		return err
+	})
+	return
+}
+
+// DeleteEvent looks for the event in every partition, newest first, since
+// its creation time isn't known. Its tombstones are then written to every
+// partition, so that older versions of a replaceable event can't be saved
+// into other months.
+func (s *ShardedBackend) DeleteEvent(ctx context.Context, id string, pubkey string) error {
+	for _, p := range s.newestFirst() {
+		evt, err := p.deleteOwnedEvent(ctx, id, pubkey)
+		if errors.Is(err, ErrEventNotFound) || errors.Is(err, ErrClosed) {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		pubkeyb, _ := hex.DecodeString(pubkey)
+		now := time.Now()
+		return s.eachPartition(func(b *BoltBackend) error {
+			if b == p.BoltBackend {
+				return nil
+			}
+			return b.update(func(tx *bolt.Tx) error {
+				return putTombstones(tx, evt, pubkeyb, now)
+			})
+		})
+	}
+	return ErrEventNotFound
+}
+
+// QueryEvents runs the filter on each partition overlapping its since and
+// until, newest first, and stops once limit events have been sent.
+func (s *ShardedBackend) QueryEvents(ctx context.Context, filter *nostr.Filter) (chan *nostr.Event, error) {
+	f := *filter
+	if _, err := checkFilter(&f); err != nil {
+		return nil, nil
+	}
+	var ps []partition
+	for _, p := range s.newestFirst() {
+		if f.Since != nil && p.end < *f.Since || f.Until != nil && p.start > *f.Until {
+			continue
+		}
+		ps = append(ps, p)
+	}
+	ch := make(chan *nostr.Event)
+	go func() {
+		defer close(ch)
+		ctx, cancel := context.WithCancel(ctx)
+		defer cancel()
+		limit := f.Limit
+		for _, p := range ps {
+			pf := f
+			pf.Limit = limit
+			events, err := p.QueryEvents(ctx, &pf)
+			if err != nil || events == nil {
+				continue
+			}
+			for evt := range events {
+				select {
+				case ch <- evt:
+					limit--
+				case <-ctx.Done():
+					// the partition's query sees ctx is done and closes
+					// its channel
+					for range events {
+					}
+					return
+				}
+			}
+			if limit <= 0 {
+				return
+			}
+		}
+	}()
+	return ch, nil
+}
+
+// DropBefore closes and deletes every partition that only holds events
+// created before t, returning their names. The partitions are taken out of
+// service first, so saves into their months fail with ErrDropping and new
+// queries skip them, while queries already running on them are canceled.
+// If closing a partition fails, it and the partitions after it are put back
+// and DropBefore may be called again to finish dropping them.
+func (s *ShardedBackend) DropBefore(ctx context.Context, t time.Time) ([]string, error) {
+	s.mu.Lock()
+	var names []string
+	for name := range s.partitions {
+		if _, end, _ := partitionRange(name); int64(end) < t.Unix() {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	// the current month's partition carries the blocklist, allowlist and
+	// tombstones over when every other partition is dropped
+	if len(names) > 0 && len(names) == len(s.partitions) {
+		current := partitionName(nostr.Now())
+		if _, end, _ := partitionRange(current); int64(end) >= t.Unix() {
+			if _, err := s.open(current); err != nil {
+				s.mu.Unlock()
+				return nil, err
+			}
+		}
+	}
+	drop := make([]*BoltBackend, len(names))
+	if s.dropping == nil {
+		s.dropping = make(map[string]struct{})
+	}
+	for i, name := range names {
+		drop[i] = s.partitions[name]
+		delete(s.partitions, name)
+		s.dropping[name] = struct{}{}
+	}
+	s.mu.Unlock()
+
+	// closing waits for the writes in progress, so it is done without
+	// holding mu
+	var dropped []string
+	var err error
+	for i, name := range names {
+		if err = drop[i].Close(ctx); err != nil {
+			break
+		}
+		if err = os.Remove(drop[i].DatabaseURL); err != nil {
+			break
+		}
+		dropped = append(dropped, name)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for i, name := range names {
+		delete(s.dropping, name)
+		if i >= len(dropped) {
+			s.partitions[name] = drop[i]
+		}
+	}
+	return dropped, err
+}
+
+// Close closes every partition, see BoltBackend.Close.
+func (s *ShardedBackend) Close(ctx context.Context) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for name, b := range s.partitions {
+		if err := b.Close(ctx); err != nil {
+			return err
+		}
+		delete(s.partitions, name)
+	}
+	return nil
+}
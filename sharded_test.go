@@ -0,0 +1,304 @@
+package bolt
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/nbd-wtf/go-nostr"
+)
+
+func TestShardedBackend(t *testing.T) {
+	dir, err := os.MkdirTemp("", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	s := &ShardedBackend{Dir: dir, Configure: func(b *BoltBackend) { b.Options.MaxBatchSize = -1 }}
+	if err := s.Init(); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx := context.Background()
+	pubkey := randHex(32)
+	months := []time.Time{
+		time.Date(2023, 6, 15, 0, 0, 0, 0, time.UTC),
+		time.Date(2023, 7, 15, 0, 0, 0, 0, time.UTC),
+		time.Date(2023, 8, 15, 0, 0, 0, 0, time.UTC),
+	}
+	var saved []nostr.Event
+	for _, m := range months {
+		for i := 0; i < 3; i++ {
+			e := nostr.Event{
+				ID:        randHex(32),
+				PubKey:    pubkey,
+				CreatedAt: nostr.Timestamp(m.Add(time.Duration(i) * time.Hour).Unix()),
+				Kind:      1,
+				Sig:       randHex(64),
+			}
+			if err := s.SaveEvent(ctx, &e); err != nil {
+				t.Fatal(err)
+			}
+			saved = append(saved, e)
+		}
+	}
+	if err := s.SaveEvent(ctx, &saved[0]); err != ErrDupEvent {
+		t.Errorf("got %v saving a duplicate, want ErrDupEvent", err)
+	}
+	for _, ts := range []nostr.Timestamp{0, nostr.Timestamp(time.Date(36812, 2, 1, 0, 0, 0, 0, time.UTC).Unix())} {
+		e := nostr.Event{ID: randHex(32), PubKey: pubkey, CreatedAt: ts, Kind: 1, Sig: randHex(64)}
+		if err := s.SaveEvent(ctx, &e); err != ErrOutOfRange {
+			t.Errorf("got %v saving an event created at %d, want ErrOutOfRange", err, ts)
+		}
+	}
+	if got := s.Partitions(); len(got) != 3 || got[0] != "2023-08" || got[2] != "2023-06" {
+		t.Fatalf("unexpected partitions %v", got)
+	}
+
+	// partitions are reopened by Init
+	if err := s.Close(ctx); err != nil {
+		t.Fatal(err)
+	}
+	s = &ShardedBackend{Dir: dir}
+	if err := s.Init(); err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close(ctx)
+
+	ch, _ := s.QueryEvents(ctx, &nostr.Filter{Authors: []string{pubkey}, Limit: 5})
+	events := collect(ch)
+	if len(events) != 5 {
+		t.Fatalf("got %d events, want 5", len(events))
+	}
+	for i, e := range events {
+		if want := saved[len(saved)-1-i].ID; e.ID != want {
+			t.Errorf("event %d is %s, want %s", i, e.ID, want)
+		}
+	}
+
+	until := nostr.Timestamp(months[1].Unix())
+	ch, _ = s.QueryEvents(ctx, &nostr.Filter{Kinds: []int{1}, Until: &until})
+	if n := len(collect(ch)); n != 4 {
+		t.Errorf("got %d events until July 15th, want 4", n)
+	}
+
+	if err := s.DeleteEvent(ctx, saved[1].ID, pubkey); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.DeleteEvent(ctx, randHex(32), pubkey); err != ErrEventNotFound {
+		t.Errorf("got %v deleting a missing event, want ErrEventNotFound", err)
+	}
+
+	dropped, err := s.DropBefore(ctx, time.Date(2023, 8, 1, 0, 0, 0, 0, time.UTC))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(dropped) != 2 {
+		t.Errorf("dropped %v, want the June and July partitions", dropped)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "2023-06.db")); !os.IsNotExist(err) {
+		t.Error("dropped partition file still exists")
+	}
+	ch, _ = s.QueryEvents(ctx, &nostr.Filter{Authors: []string{pubkey}})
+	if n := len(collect(ch)); n != 3 {
+		t.Errorf("got %d events after dropping partitions, want 3", n)
+	}
+}
+
+func TestShardedDropBefore(t *testing.T) {
+	dir, err := os.MkdirTemp("", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	s := &ShardedBackend{Dir: dir, Configure: func(b *BoltBackend) { b.Options.MaxBatchSize = -1 }}
+	if err := s.Init(); err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close(context.Background())
+
+	ctx := context.Background()
+	event := func(m time.Month) *nostr.Event {
+		return &nostr.Event{
+			ID:        randHex(32),
+			PubKey:    randHex(32),
+			CreatedAt: nostr.Timestamp(time.Date(2023, m, 15, 0, 0, 0, 0, time.UTC).Unix()),
+			Kind:      1,
+			Sig:       randHex(64),
+		}
+	}
+	for _, m := range []time.Month{6, 7, 8} {
+		if err := s.SaveEvent(ctx, event(m)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	cutoff := time.Date(2023, 8, 1, 0, 0, 0, 0, time.UTC)
+
+	// a write in progress on June keeps it from closing
+	s.mu.RLock()
+	done, _ := s.partitions["2023-06"].begin()
+	s.mu.RUnlock()
+	timeout, cancel := context.WithTimeout(ctx, 50*time.Millisecond)
+	defer cancel()
+	if dropped, err := s.DropBefore(timeout, cutoff); err != context.DeadlineExceeded || len(dropped) != 0 {
+		t.Fatalf("got %v, %v dropping a busy partition", dropped, err)
+	}
+	if got := s.Partitions(); len(got) != 3 {
+		t.Fatalf("busy partition not put back: %v", got)
+	}
+
+	// the other partitions stay usable while the drop waits
+	type result struct {
+		dropped []string
+		err     error
+	}
+	res := make(chan result)
+	go func() {
+		dropped, err := s.DropBefore(ctx, cutoff)
+		res <- result{dropped, err}
+	}()
+	for len(s.Partitions()) != 1 {
+		time.Sleep(time.Millisecond)
+	}
+	if err := s.SaveEvent(ctx, event(8)); err != nil {
+		t.Error("saving into a kept partition during a drop", err)
+	}
+	if err := s.SaveEvent(ctx, event(6)); err != ErrDropping {
+		t.Errorf("got %v saving into a partition being dropped, want ErrDropping", err)
+	}
+	done()
+	r := <-res
+	if r.err != nil || len(r.dropped) != 2 {
+		t.Fatalf("dropped %v, %v, want the June and July partitions", r.dropped, r.err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "2023-06.db")); !os.IsNotExist(err) {
+		t.Error("dropped partition file still exists")
+	}
+	// the month can be saved into again once its file is gone
+	if err := s.SaveEvent(ctx, event(6)); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestShardedControls(t *testing.T) {
+	dir, err := os.MkdirTemp("", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	s := &ShardedBackend{Dir: dir, Configure: func(b *BoltBackend) {
+		b.Options.MaxBatchSize = -1
+		b.MaxAuthorEvents = 2
+	}}
+	if err := s.Init(); err != nil {
+		t.Fatal(err)
+	}
+	defer func() { s.Close(context.Background()) }()
+
+	ctx := context.Background()
+	event := func(pubkey string, m time.Month, kind int) *nostr.Event {
+		return &nostr.Event{
+			ID:        randHex(32),
+			PubKey:    pubkey,
+			CreatedAt: nostr.Timestamp(time.Date(2023, m, 15, 0, 0, 0, 0, time.UTC).Unix()),
+			Kind:      kind,
+			Sig:       randHex(64),
+		}
+	}
+
+	// entries added before any event is saved are kept
+	spammer, member := randHex(32), randHex(32)
+	if _, err := s.Block(ctx, BlockPubKey, spammer, false); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Allow(member, time.Time{}); err != nil {
+		t.Fatal(err)
+	}
+	if got := s.Partitions(); len(got) != 1 {
+		t.Fatalf("unexpected partitions %v", got)
+	}
+	// and copied into new partitions
+	if err := s.SaveEvent(ctx, event(spammer, 6, 1)); err != ErrBlocked {
+		t.Errorf("got %v saving a blocked pubkey into a new partition, want ErrBlocked", err)
+	}
+	if !s.IsAllowed(member) {
+		t.Error("allowed pubkey not allowed")
+	}
+	s.mu.RLock()
+	allowed := s.partitions["2023-06"].IsAllowed(member)
+	s.mu.RUnlock()
+	if !allowed {
+		t.Error("allowlist not copied into a new partition")
+	}
+
+	// blocking applies to and purges every partition
+	if err := s.Unblock(BlockPubKey, spammer); err != nil {
+		t.Fatal(err)
+	}
+	for _, m := range []time.Month{6, 7} {
+		if err := s.SaveEvent(ctx, event(spammer, m, 1)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if n, err := s.Block(ctx, BlockPubKey, spammer, true); err != nil || n != 2 {
+		t.Errorf("purged %d events, %v, want 2", n, err)
+	}
+	if err := s.SaveEvent(ctx, event(spammer, 7, 1)); err != ErrBlocked {
+		t.Errorf("got %v saving a blocked pubkey, want ErrBlocked", err)
+	}
+	if blocked, _ := s.Blocked(BlockPubKey); len(blocked) != 1 || blocked[0] != spammer {
+		t.Error("unexpected blocklist", blocked)
+	}
+
+	// deleting a replaceable event rejects older versions in other months
+	author := randHex(32)
+	profile := event(author, 7, 0)
+	if err := s.SaveEvent(ctx, profile); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.DeleteEvent(ctx, profile.ID, author); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.SaveEvent(ctx, event(author, 6, 0)); err != ErrDeleted {
+		t.Errorf("got %v saving an older version into another partition, want ErrDeleted", err)
+	}
+	if err := s.SaveEvent(ctx, event(author, 5, 0)); err != ErrDeleted {
+		t.Errorf("got %v saving an older version into a new partition, want ErrDeleted", err)
+	}
+
+	// quotas count every partition
+	for _, m := range []time.Month{6, 7} {
+		if err := s.SaveEvent(ctx, event(member, m, 1)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	var qe *QuotaError
+	if err := s.SaveEvent(ctx, event(member, 8, 1)); !errors.As(err, &qe) || qe.Usage.Events != 2 {
+		t.Errorf("got %v saving over quota in another partition, want a QuotaError", err)
+	}
+	if u, err := s.AuthorUsage(member); err != nil || u.Events != 2 {
+		t.Errorf("got usage %+v, %v, want 2 events", u, err)
+	}
+
+	// dropping every partition creates the current month's to keep them
+	if err := s.Close(ctx); err != nil {
+		t.Fatal(err)
+	}
+	os.Remove(filepath.Join(dir, partitionName(nostr.Now())+".db"))
+	s = &ShardedBackend{Dir: dir}
+	if err := s.Init(); err != nil {
+		t.Fatal(err)
+	}
+	if dropped, err := s.DropBefore(ctx, time.Now()); err != nil || len(dropped) != 4 {
+		t.Fatalf("dropped %v, %v, want every partition", dropped, err)
+	}
+	if got := s.Partitions(); len(got) != 1 || got[0] != partitionName(nostr.Now()) {
+		t.Errorf("unexpected partitions %v", got)
+	}
+	if blocked, _ := s.Blocked(BlockPubKey); len(blocked) != 1 {
+		t.Error("blocklist lost when dropping every partition", blocked)
+	}
+}
@@ -0,0 +1,121 @@
+package bolt
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// checkReadableSchema makes sure a database opened read-only can be used
+// as is, since it can't be initialized or migrated.
+func checkReadableSchema(tx *bolt.Tx) error {
+	version, ok := readSchemaVersion(tx)
+	switch {
+	case !ok:
+		return errors.New("database has not been initialized")
+	case version > schemaVersion:
+		return fmt.Errorf("database schema version %d is newer than supported version %d", version, schemaVersion)
+	case version < schemaVersion:
+		return fmt.Errorf("database schema version %d must be migrated by opening it for writing", version)
+	}
+	if _, ok := rebuildProgress(tx); ok {
+		return errors.New("index rebuild was interrupted, open the database for writing to finish it")
+	}
+	return nil
+}
+
+// Reopen replaces the open database with the file now at DatabaseURL. It is
+// meant for read-only backends serving a copy of another database, such as
+// one made with Backup: write the new copy next to the file, rename it over
+// the file and call Reopen, or set Options.RefreshInterval to have it done
+// automatically. Reopen doesn't wait for the queries that are running, they
+// finish on the previous file, which is closed once they are done.
+func (b *BoltBackend) Reopen() error {
+	if !b.Options.ReadOnly {
+		return errors.New("only read-only databases can be reopened")
+	}
+	done, err := b.begin()
+	if err != nil {
+		return err
+	}
+	defer done()
+	fi, err := os.Stat(b.DatabaseURL)
+	if err != nil {
+		return err
+	}
+	db, err := b.openDB(b.DatabaseURL, true)
+	if err != nil {
+		return err
+	}
+	if err := db.View(checkReadableSchema); err != nil {
+		db.Close()
+		return err
+	}
+	b.dbMu.Lock()
+	b.snapshot = fi
+	b.dbMu.Unlock()
+	b.replaceDB(db)
+	return nil
+}
+
+// snapshotChanged reports whether the file at DatabaseURL is no longer the
+// one that was opened.
+func (b *BoltBackend) snapshotChanged() (bool, error) {
+	fi, err := os.Stat(b.DatabaseURL)
+	if err != nil {
+		return false, err
+	}
+	b.dbMu.RLock()
+	defer b.dbMu.RUnlock()
+	return b.snapshot == nil || !os.SameFile(fi, b.snapshot) ||
+		!fi.ModTime().Equal(b.snapshot.ModTime()) || fi.Size() != b.snapshot.Size(), nil
+}
+
+// startRefresh starts the loop reopening the database whenever its file is
+// replaced, if Options.RefreshInterval asks for it.
+func (b *BoltBackend) startRefresh() {
+	if !b.Options.ReadOnly || b.Options.RefreshInterval <= 0 {
+		return
+	}
+	b.snapshot, _ = os.Stat(b.DatabaseURL)
+	b.refreshQuit = make(chan struct{})
+	b.refreshDone = make(chan struct{})
+	go b.refreshLoop(b.refreshQuit, b.refreshDone)
+}
+
+func (b *BoltBackend) refreshLoop(quit, done chan struct{}) {
+	defer close(done)
+	ticker := time.NewTicker(b.Options.RefreshInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-quit:
+			return
+		case <-ticker.C:
+			changed, err := b.snapshotChanged()
+			if err != nil {
+				b.logf("checking %s for a new snapshot: %v", b.DatabaseURL, err)
+				continue
+			}
+			if !changed {
+				continue
+			}
+			if err := b.Reopen(); err != nil && err != ErrClosed {
+				b.logf("reopening %s: %v", b.DatabaseURL, err)
+			}
+		}
+	}
+}
+
+// stopRefresh stops the refresh loop, if it was started, and waits for it
+// to return.
+func (b *BoltBackend) stopRefresh() {
+	if b.refreshQuit != nil {
+		close(b.refreshQuit)
+		<-b.refreshDone
+		b.refreshQuit = nil
+	}
+}
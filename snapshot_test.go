@@ -0,0 +1,118 @@
+package bolt
+
+import (
+	"context"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/nbd-wtf/go-nostr"
+	bolt "go.etcd.io/bbolt"
+)
+
+func TestReadOnlySnapshots(t *testing.T) {
+	f, _ := os.CreateTemp("", "")
+	f.Close()
+	defer os.Remove(f.Name())
+	w := &BoltBackend{DatabaseURL: f.Name() + ".primary"}
+	w.Init()
+	defer os.Remove(w.DatabaseURL)
+	defer w.DB.Close()
+	w.DB.MaxBatchSize = 0
+
+	ctx := context.Background()
+	save := func() *nostr.Event {
+		e := &nostr.Event{ID: randHex(32), PubKey: randHex(32), CreatedAt: nostr.Timestamp(time.Now().Unix()), Kind: 1, Sig: randHex(64)}
+		if err := w.SaveEvent(ctx, e); err != nil {
+			t.Fatal(err)
+		}
+		return e
+	}
+	// publish copies the primary database over the reader's file.
+	publish := func() {
+		tmp, err := os.Create(f.Name() + ".tmp")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := w.Backup(tmp); err != nil {
+			t.Fatal(err)
+		}
+		tmp.Close()
+		if err := os.Rename(tmp.Name(), f.Name()); err != nil {
+			t.Fatal(err)
+		}
+	}
+	count := func(r *BoltBackend) int {
+		ch, _ := r.QueryEvents(ctx, &nostr.Filter{Kinds: []int{1}})
+		return len(collect(ch))
+	}
+
+	e := save()
+	publish()
+	r := &BoltBackend{DatabaseURL: f.Name(), Options: Options{ReadOnly: true, RefreshInterval: 10 * time.Millisecond}}
+	if err := r.Init(); err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close(ctx)
+
+	if err := r.SaveEvent(ctx, &nostr.Event{ID: randHex(32), PubKey: randHex(32), Kind: 1}); err != ErrReadOnly {
+		t.Errorf("SaveEvent returned %v, want ErrReadOnly", err)
+	}
+	if err := r.DeleteEvent(ctx, e.ID, e.PubKey); err != ErrReadOnly {
+		t.Errorf("DeleteEvent returned %v, want ErrReadOnly", err)
+	}
+	if _, err := r.Compact(); err != ErrReadOnly {
+		t.Errorf("Compact returned %v, want ErrReadOnly", err)
+	}
+	if n := count(r); n != 1 {
+		t.Fatalf("read %d events, want 1", n)
+	}
+
+	waitFor := func(n int) {
+		deadline := time.Now().Add(5 * time.Second)
+		for count(r) != n {
+			if time.Now().After(deadline) {
+				t.Fatalf("new snapshot with %d events was not picked up", n)
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+	}
+	save()
+	publish()
+	waitFor(2)
+
+	// a query abandoned after reading a single event doesn't hold up the
+	// refreshes
+	ch, _ := r.QueryEvents(ctx, &nostr.Filter{Kinds: []int{1}})
+	<-ch
+	for n := 3; n <= 4; n++ {
+		save()
+		publish()
+		waitFor(n)
+	}
+
+	// a read-only backend can't initialize a new database
+	os.Remove(f.Name() + ".empty")
+	defer os.Remove(f.Name() + ".empty")
+	empty := &BoltBackend{DatabaseURL: f.Name() + ".empty", Options: Options{ReadOnly: true}}
+	if err := empty.Init(); err == nil {
+		empty.DB.Close()
+		t.Error("opened a missing database read-only")
+	}
+
+	// a failed Init doesn't keep the file locked
+	os.Remove(f.Name() + ".uninitialized")
+	defer os.Remove(f.Name() + ".uninitialized")
+	if db, err := bolt.Open(f.Name()+".uninitialized", 0600, nil); err == nil {
+		db.Close()
+	}
+	ro := &BoltBackend{DatabaseURL: f.Name() + ".uninitialized", Options: Options{ReadOnly: true}}
+	if err := ro.Init(); err == nil {
+		t.Fatal("opened an uninitialized database read-only")
+	}
+	w2 := &BoltBackend{DatabaseURL: f.Name() + ".uninitialized"}
+	if err := w2.Init(); err != nil {
+		t.Fatal(err)
+	}
+	w2.Close(ctx)
+}
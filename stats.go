@@ -0,0 +1,128 @@
+package bolt
+
+import (
+	"context"
+	"encoding/binary"
+	"encoding/hex"
+	"sort"
+
+	"github.com/nbd-wtf/go-nostr"
+	bolt "go.etcd.io/bbolt"
+)
+
+// defaultStatsTopAuthors is used when StatsTopAuthors isn't set.
+const defaultStatsTopAuthors = 10
+
+// AuthorCount is the number of events stored for a pubkey.
+type AuthorCount struct {
+	PubKey string
+	Events int
+}
+
+// StatsReport describes the contents of the database.
+type StatsReport struct {
+	Events int
+	// Kinds maps each stored kind to its number of events.
+	Kinds map[int]int
+	// TopAuthors lists the authors with the most events, most first.
+	TopAuthors []AuthorCount
+	// Authors is the number of distinct authors.
+	Authors int
+	// TagValues maps each indexed tag letter to its number of distinct
+	// values.
+	TagValues map[string]int
+	// Oldest and Newest are the creation times of the oldest and newest
+	// events. They are zero if there are no events.
+	Oldest, Newest nostr.Timestamp
+	// Size is the size of the database file in bytes.
+	Size int64
+}
+
+// Stats counts the stored events by kind, author and tag, reading only the
+// indexes.
+//
+// Stats hides the Stats method of the embedded *bolt.DB: b.Stats() no longer
+// returns a bolt.Stats. Callers that want bbolt's page and transaction
+// statistics must call b.DB.Stats() instead.
+func (b *BoltBackend) Stats(ctx context.Context) (*StatsReport, error) {
+	top := b.StatsTopAuthors
+	if top <= 0 {
+		top = defaultStatsTopAuthors
+	}
+	r := &StatsReport{
+		Kinds:     make(map[int]int),
+		TagValues: make(map[string]int),
+	}
+	err := b.view(func(tx *bolt.Tx) error {
+		r.Size = tx.Size()
+		r.Events = tx.Bucket([]byte("events")).Stats().KeyN
+
+		c := tx.Bucket([]byte("timestamp_ids")).Cursor()
+		if k, _ := c.First(); len(k) >= 8 {
+			r.Oldest = nostr.Timestamp(binary.BigEndian.Uint64(k[:8]))
+		}
+		if k, _ := c.Last(); len(k) >= 8 {
+			r.Newest = nostr.Timestamp(binary.BigEndian.Uint64(k[:8]))
+		}
+
+		kinds := tx.Bucket([]byte("kinds"))
+		err := kinds.ForEach(func(k, _ []byte) error {
+			if sb := kinds.Bucket(k); sb != nil && len(k) == 8 {
+				r.Kinds[int(binary.BigEndian.Uint64(k))] = sb.Stats().KeyN
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+
+		authors := tx.Bucket([]byte("authors"))
+		err = authors.ForEach(func(k, _ []byte) error {
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+			sb := authors.Bucket(k)
+			if sb == nil {
+				return nil
+			}
+			r.Authors++
+			n := sb.Stats().KeyN
+			if len(r.TopAuthors) == top && n <= r.TopAuthors[top-1].Events {
+				return nil
+			}
+			i := sort.Search(len(r.TopAuthors), func(i int) bool { return r.TopAuthors[i].Events < n })
+			if len(r.TopAuthors) < top {
+				r.TopAuthors = append(r.TopAuthors, AuthorCount{})
+			}
+			copy(r.TopAuthors[i+1:], r.TopAuthors[i:])
+			r.TopAuthors[i] = AuthorCount{PubKey: hex.EncodeToString(k), Events: n}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+
+		return tx.ForEach(func(name []byte, tb *bolt.Bucket) error {
+			if !isTagBucket(name) {
+				return nil
+			}
+			n := 0
+			c := tb.Cursor()
+			for k, _ := c.First(); k != nil; k, _ = c.Next() {
+				if err := ctx.Err(); err != nil {
+					return err
+				}
+				n++
+			}
+			r.TagValues[string(name)] = n
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+	if size, err := b.fileSize(); err == nil {
+		r.Size = size
+	}
+	return r, nil
+}
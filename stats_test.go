@@ -0,0 +1,64 @@
+package bolt
+
+import (
+	"context"
+	"os"
+	"testing"
+
+	"github.com/nbd-wtf/go-nostr"
+)
+
+func TestStats(t *testing.T) {
+	f, _ := os.CreateTemp("", "")
+	f.Close()
+	defer os.Remove(f.Name())
+	s := &BoltBackend{DatabaseURL: f.Name(), StatsTopAuthors: 2}
+	s.Init()
+	s.DB.MaxBatchSize = 0
+
+	ctx := context.Background()
+	authors := []string{randHex(32), randHex(32), randHex(32)}
+	// author i stores i+1 events
+	n := 0
+	for i, pubkey := range authors {
+		for j := 0; j <= i; j++ {
+			e := nostr.Event{
+				ID:        randHex(32),
+				PubKey:    pubkey,
+				CreatedAt: nostr.Timestamp(100 + n),
+				Kind:      j,
+				Tags:      nostr.Tags{{"t", pubkey[:4]}, {"e", randHex(32)}},
+				Sig:       randHex(64),
+			}
+			if err := s.SaveEvent(ctx, &e); err != nil {
+				t.Fatal(err)
+			}
+			n++
+		}
+	}
+
+	r, err := s.Stats(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.Events != 6 || r.Authors != 3 {
+		t.Errorf("got %d events from %d authors, want 6 from 3", r.Events, r.Authors)
+	}
+	if r.Kinds[0] != 3 || r.Kinds[1] != 2 || r.Kinds[2] != 1 || len(r.Kinds) != 3 {
+		t.Errorf("unexpected kind counts %v", r.Kinds)
+	}
+	if len(r.TopAuthors) != 2 ||
+		r.TopAuthors[0] != (AuthorCount{authors[2], 3}) ||
+		r.TopAuthors[1] != (AuthorCount{authors[1], 2}) {
+		t.Errorf("unexpected top authors %v", r.TopAuthors)
+	}
+	if r.TagValues["t"] != 3 || r.TagValues["e"] != 6 {
+		t.Errorf("unexpected tag values %v", r.TagValues)
+	}
+	if r.Oldest != 100 || r.Newest != 105 {
+		t.Errorf("got timestamps %d-%d, want 100-105", r.Oldest, r.Newest)
+	}
+	if r.Size <= 0 {
+		t.Errorf("unexpected size %d", r.Size)
+	}
+}
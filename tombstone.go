@@ -0,0 +1,102 @@
+package bolt
+
+import (
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"time"
+
+	"github.com/nbd-wtf/go-nostr"
+	bolt "go.etcd.io/bbolt"
+)
+
+// Tombstones are kept in the "tombstones" bucket under the raw event id and,
+// for replaceable and parameterized replaceable events, under the event's
+// "<kind>:<pubkey>:<d tag>" address. Values are the deleter's pubkey
+// followed by the deletion time.
+
+// eventAddress returns the address of a replaceable or parameterized
+// replaceable event, or nil for other kinds.
+func eventAddress(evt *nostr.Event) []byte {
+	var d string
+	switch {
+	case evt.Kind == 0 || evt.Kind == 3 || (evt.Kind >= 10000 && evt.Kind < 20000):
+	case evt.Kind >= 30000 && evt.Kind < 40000:
+		if tag := evt.Tags.GetFirst([]string{"d", ""}); tag != nil {
+			d = tag.Value()
+		}
+	default:
+		return nil
+	}
+	return []byte(fmt.Sprintf("%d:%s:%s", evt.Kind, evt.PubKey, d))
+}
+
+// putTombstones records that evt was deleted by pubkey at now.
+func putTombstones(tx *bolt.Tx, evt *nostr.Event, pubkey []byte, now time.Time) error {
+	tombstones := tx.Bucket([]byte("tombstones"))
+	v := make([]byte, 32+8)
+	copy(v, pubkey)
+	binary.BigEndian.PutUint64(v[32:], uint64(now.Unix()))
+	idb, _ := hex.DecodeString(evt.ID)
+	if err := tombstones.Put(idb, v); err != nil {
+		return err
+	}
+	if addr := eventAddress(evt); addr != nil {
+		return tombstones.Put(addr, v)
+	}
+	return nil
+}
+
+// tombstoneTime returns when the tombstone under key was written, if there
+// is one that hasn't outlived b.TombstoneLifetime.
+func (b *BoltBackend) tombstoneTime(tx *bolt.Tx, key []byte, now time.Time) (time.Time, bool) {
+	v := tx.Bucket([]byte("tombstones")).Get(key)
+	if len(v) != 32+8 {
+		return time.Time{}, false
+	}
+	deletedAt := time.Unix(int64(binary.BigEndian.Uint64(v[32:])), 0)
+	if b.TombstoneLifetime > 0 && now.Sub(deletedAt) > b.TombstoneLifetime {
+		return time.Time{}, false
+	}
+	return deletedAt, true
+}
+
+// checkTombstones returns ErrDeleted if evt, or an older version of the
+// replaceable event it belongs to, has been deleted.
+func (b *BoltBackend) checkTombstones(tx *bolt.Tx, evt *nostr.Event, idb []byte) error {
+	now := time.Now()
+	if _, ok := b.tombstoneTime(tx, idb, now); ok {
+		return ErrDeleted
+	}
+	if addr := eventAddress(evt); addr != nil {
+		if deletedAt, ok := b.tombstoneTime(tx, addr, now); ok && int64(evt.CreatedAt) <= deletedAt.Unix() {
+			return ErrDeleted
+		}
+	}
+	return nil
+}
+
+// ClearTombstones removes tombstones written more than olderThan ago, or
+// every tombstone if olderThan is zero, and returns how many were removed.
+func (b *BoltBackend) ClearTombstones(olderThan time.Duration) (n int, err error) {
+	cutoff := uint64(time.Now().Add(-olderThan).Unix())
+	err = b.update(func(tx *bolt.Tx) error {
+		n = 0
+		tombstones := tx.Bucket([]byte("tombstones"))
+		var keys [][]byte
+		tombstones.ForEach(func(k, v []byte) error {
+			if olderThan == 0 || len(v) != 32+8 || binary.BigEndian.Uint64(v[32:]) < cutoff {
+				keys = append(keys, append([]byte(nil), k...))
+			}
+			return nil
+		})
+		for _, k := range keys {
+			if err := tombstones.Delete(k); err != nil {
+				return err
+			}
+		}
+		n = len(keys)
+		return nil
+	})
+	return
+}
@@ -23,6 +23,7 @@ func makeEventIndexBytes(evt *nostr.Event) *eventIndexBytes {
 	r.TimestampID = make([]byte, 8+32)
 	binary.BigEndian.PutUint64(r.TimestampID, uint64(evt.CreatedAt))
 	copy(r.TimestampID[8:], r.ID)
+	r.Timestamp = r.TimestampID[:8:8]
 	r.Kind = make([]byte, 8)
 	binary.BigEndian.PutUint64(r.Kind, uint64(evt.Kind))
 	r.Tags = make(map[string][][]byte, 2)
@@ -50,8 +51,10 @@ func makeFilterIndexBytes(filter *nostr.Filter) *filterIndexBytes {
 		binary.BigEndian.PutUint64(r.Since, uint64(*filter.Since))
 	}
 	if filter.Until != nil {
-		r.Until = []byte{255, 255, 255, 255, 255, 255, 255, 255}
-		binary.BigEndian.PutUint64(r.Until, uint64(*filter.Until)-1)
+		// the first timestamp after until, so seeking to it lands past
+		// every event created at until
+		r.Until = make([]byte, 8)
+		binary.BigEndian.PutUint64(r.Until, uint64(*filter.Until)+1)
 	}
 	r.IDs = make([][]byte, len(filter.IDs))
 	for i, id := range filter.IDs {
@@ -0,0 +1,44 @@
+package bolt
+
+import (
+	"encoding/hex"
+	"fmt"
+
+	"github.com/nbd-wtf/go-nostr"
+)
+
+// validateEvent checks that evt is well formed before it is stored: the id,
+// pubkey and signature must be hex of the right length, the id must be the
+// hash of the serialized event and the signature must verify.
+func validateEvent(evt *nostr.Event) error {
+	if err := checkHex("id", evt.ID, 32); err != nil {
+		return err
+	}
+	if err := checkHex("pubkey", evt.PubKey, 32); err != nil {
+		return err
+	}
+	if err := checkHex("sig", evt.Sig, 64); err != nil {
+		return err
+	}
+	if id := evt.GetID(); id != evt.ID {
+		return fmt.Errorf("%w: id does not match event hash %s", ErrInvalidEvent, id)
+	}
+	ok, err := evt.CheckSignature()
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidEvent, err)
+	}
+	if !ok {
+		return fmt.Errorf("%w: signature verification failed", ErrInvalidEvent)
+	}
+	return nil
+}
+
+func checkHex(field, s string, n int) error {
+	if len(s) != 2*n {
+		return fmt.Errorf("%w: %s must be %d hex characters, got %d", ErrInvalidEvent, field, 2*n, len(s))
+	}
+	if _, err := hex.DecodeString(s); err != nil {
+		return fmt.Errorf("%w: %s is not valid hex", ErrInvalidEvent, field)
+	}
+	return nil
+}